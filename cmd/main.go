@@ -5,22 +5,95 @@ import (
 	"crypto/x509"
 	"fmt"
 	"log"
+	"net/mail"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lucap9056/go-lifecycle/lifecycle"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/dkim"
 	"github.com/lucap9056/mail-template-sender/internal/grpclistener"
 	"github.com/lucap9056/mail-template-sender/internal/httplistener"
+	"github.com/lucap9056/mail-template-sender/internal/lmtp"
+	"github.com/lucap9056/mail-template-sender/internal/mailer"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/quota"
+	"github.com/lucap9056/mail-template-sender/internal/ratelimit"
+	"github.com/lucap9056/mail-template-sender/internal/relay"
+	"github.com/lucap9056/mail-template-sender/internal/routing"
+	"github.com/lucap9056/mail-template-sender/internal/smime"
 	"github.com/lucap9056/mail-template-sender/internal/smtp"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
 	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
+	"github.com/lucap9056/mail-template-sender/internal/unsubscribe"
+	"github.com/lucap9056/mail-template-sender/internal/webhook"
 )
 
+// memoryTransportLimit is the number of messages a memory transport keeps.
+const memoryTransportLimit = 1000
+
+// sendmailTimeout bounds a run of the sendmail command.
+const sendmailTimeout = 2 * time.Minute
+
 type ENV struct {
 	SMTP_USERNAME               string
 	SMTP_PASSWORD               string
 	SMTP_SERVER_ADDRESS         string
+	SMTP_ENVELOPE_FROM          string
+	TRANSPORT                   string
+	TRANSPORT_DIRECTORY         string
+	TRANSPORT_COMMAND           string
+	SMTP_BACKENDS               []*smtpBackend
+	SMTP_FAILOVER_COOLDOWN      time.Duration
+	SMTP_ROUTES                 []*routing.Rule
+	MAIL_FROM_ADDRESS           string
+	MAIL_FROM_NAME              string
+	ALLOWED_FROM_ADDRESSES      []string
 	EMAIL_TEMPLATES_DIRECTORY   string
+	TEMPLATE_ACTIVE_VERSIONS    map[string]string
+	ALLOWED_CUSTOM_HEADERS      []string
+	QUEUE_PATH                  string
+	QUEUE_WORKERS               int
+	QUEUE_RETENTION             time.Duration
+	RETRY_MAX_ATTEMPTS          int
+	RETRY_MAX_AGE               time.Duration
+	RETRY_INITIAL_INTERVAL      time.Duration
+	RETRY_MAX_INTERVAL          time.Duration
+	RETRY_MULTIPLIER            float64
+	RETRY_JITTER                float64
+	IDEMPOTENCY_WINDOW          time.Duration
+	SMTP_RATE_LIMITS            []ratelimit.Limit
+	DOMAIN_RATE_LIMITS          []ratelimit.Limit
+	DOMAIN_CONCURRENCY          int
+	DKIM_KEYS                   []*dkim.Key
+	SMIME_CERTIFICATE_PATH      string
+	SMIME_KEY_PATH              string
+	SMIME_RECIPIENTS_DIRECTORY  string
+	PGP_KEYS_DIRECTORY          string
+	PGP_SIGNING_KEY_PATH        string
+	PGP_SIGNING_KEY_PASSPHRASE  string
+	PGP_FALLBACK                string
+	QUOTA_HOURLY                int
+	QUOTA_DAILY                 int
+	API_KEYS                    map[string]string
+	ADMIN_API_KEYS              map[string]string
+	TRUSTED_PROXIES             []string
+	SUPPRESSION_PATH            string
+	UNSUBSCRIBE_URL             string
+	UNSUBSCRIBE_SECRET          string
+	UNSUBSCRIBE_HTTP_ADDRESS    string
+	WEBHOOK_URLS                []string
+	WEBHOOK_SECRET              string
+	WEBHOOK_EVENTS              []string
+	WEBHOOK_TIMEOUT             time.Duration
+	WEBHOOK_MAX_ATTEMPTS        int
+	WEBHOOK_WORKERS             int
+	WEBHOOK_QUEUE_PATH          string
 	TLS_CA_CERTIFICATE_PATH     string
 	TLS_SERVER_CERTIFICATE_PATH string
 	TLS_SERVER_KEY_PATH         string
@@ -49,6 +122,228 @@ func getEnabledListeners(enabledListeners string) map[string]struct{} {
 	return listenerMap
 }
 
+// getList parses a comma separated list, skipping empty entries.
+func getList(value string) []string {
+	list := []string{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// getKeyValues parses a comma separated list of key=value pairs.
+func getKeyValues(value string) map[string]string {
+	pairs := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		pairs[key] = strings.TrimSpace(value)
+	}
+
+	return pairs
+}
+
+// getInt parses an integer setting, returning def when it is not set.
+func getInt(value string, def int) int {
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		log.Fatalf("Invalid integer setting %q: %s\n", value, err.Error())
+	}
+
+	return n
+}
+
+// getFloat parses a decimal setting, returning def when it is not set.
+func getFloat(value string, def float64) float64 {
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		log.Fatalf("Invalid decimal setting %q: %s\n", value, err.Error())
+	}
+
+	return n
+}
+
+// getDuration parses a duration setting such as "30s" or "1h", returning def
+// when it is not set.
+func getDuration(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		log.Fatalf("Invalid duration setting %q: %s\n", value, err.Error())
+	}
+
+	return d
+}
+
+// getLimits parses a comma separated list of rate limits such as
+// "10/s,500/m,10000/d".
+func getLimits(value string) []ratelimit.Limit {
+	limits := []ratelimit.Limit{}
+
+	for _, entry := range getList(value) {
+		limit, err := ratelimit.ParseLimit(entry)
+		if err != nil {
+			log.Fatalf("Invalid rate limit setting: %s\n", err.Error())
+		}
+		limits = append(limits, limit)
+	}
+
+	return limits
+}
+
+// getDKIMKeys parses a comma separated list of domain:selector:path entries
+// and loads the private keys.
+func getDKIMKeys(value string) []*dkim.Key {
+	keys := []*dkim.Key{}
+
+	for _, entry := range getList(value) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			log.Fatalf("Invalid DKIM key setting %q, expected domain:selector:path\n", entry)
+		}
+
+		key, err := dkim.LoadKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			log.Fatalf("Failed to load DKIM key: %s\n", err.Error())
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// getAPIKeys parses the name:key list of the variable name.
+func getAPIKeys(name string) map[string]string {
+	keys, err := auth.ParseKeys(os.Getenv(name))
+	if err != nil {
+		log.Fatalf("Invalid %s setting: %s\n", name, err.Error())
+	}
+
+	return keys
+}
+
+// getRoutes parses the routing rules of the SMTP backends.
+func getRoutes(value string) []*routing.Rule {
+	rules, err := routing.ParseRules(value)
+	if err != nil {
+		log.Fatalf("Invalid SMTP_ROUTES setting: %s\n", err.Error())
+	}
+
+	return rules
+}
+
+// smtpBackend holds the settings of a named backend.
+type smtpBackend struct {
+	name      string
+	transport string
+	config    *smtp.SMTPConfig
+	directory string
+	command   string
+	priority  int
+	weight    int
+}
+
+// getSMTPBackends parses a comma separated list of backend names. Each
+// backend is configured by SMTP_<NAME>_TRANSPORT, SMTP_<NAME>_SERVER_ADDRESS,
+// SMTP_<NAME>_USERNAME, SMTP_<NAME>_PASSWORD, SMTP_<NAME>_DIRECTORY,
+// SMTP_<NAME>_COMMAND, SMTP_<NAME>_PRIORITY and SMTP_<NAME>_WEIGHT.
+func getSMTPBackends(value string) []*smtpBackend {
+	backends := []*smtpBackend{}
+
+	for _, name := range getList(value) {
+		prefix := "SMTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		backend := &smtpBackend{
+			name:      name,
+			transport: getTransport(os.Getenv(prefix + "TRANSPORT")),
+			config: &smtp.SMTPConfig{
+				Username: os.Getenv(prefix + "USERNAME"),
+				Password: os.Getenv(prefix + "PASSWORD"),
+				Address:  os.Getenv(prefix + "SERVER_ADDRESS"),
+			},
+			directory: os.Getenv(prefix + "DIRECTORY"),
+			command:   os.Getenv(prefix + "COMMAND"),
+			priority:  getInt(os.Getenv(prefix+"PRIORITY"), 0),
+			weight:    getInt(os.Getenv(prefix+"WEIGHT"), 1),
+		}
+
+		if (backend.transport == "smtp" || backend.transport == "lmtp") && backend.config.Address == "" {
+			log.Fatalf("%sSERVER_ADDRESS is required for %s backend %s\n", prefix, backend.transport, name)
+		}
+
+		backends = append(backends, backend)
+	}
+
+	return backends
+}
+
+// getTransport validates a transport setting, defaulting to smtp.
+func getTransport(value string) string {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "":
+		return "smtp"
+	case "smtp", "lmtp", "sendmail", "file", "stdout", "memory":
+		return value
+	}
+
+	log.Fatalf("Invalid transport %q, expected smtp, lmtp, sendmail, file, stdout or memory\n", value)
+	return ""
+}
+
+// newTransport creates the transport of a backend. SMTP and LMTP backends
+// connect on their first send unless eager is set. Memory backends share
+// outbox.
+func newTransport(backend *smtpBackend, eager bool, outbox *transport.Memory) (transport.Transport, error) {
+	switch backend.transport {
+	case "lmtp":
+		config := &lmtp.LMTPConfig{Address: backend.config.Address}
+		if eager {
+			return lmtp.New(config)
+		}
+		return lmtp.NewLazy(config)
+	case "sendmail":
+		if backend.command == "" {
+			backend.command = "/usr/sbin/sendmail -i"
+		}
+		return transport.NewSendmail(backend.command, sendmailTimeout)
+	case "file":
+		if backend.directory == "" {
+			backend.directory = "./outbox"
+		}
+		return transport.NewFile(backend.directory)
+	case "stdout":
+		return transport.NewStdout(), nil
+	case "memory":
+		return outbox, nil
+	}
+
+	if eager {
+		return smtp.New(backend.config)
+	}
+
+	return smtp.NewLazy(backend.config)
+}
+
 func isTLSConfigured(cert, key string) bool {
 	return cert != "" && key != ""
 }
@@ -61,7 +356,56 @@ func main() {
 		SMTP_USERNAME:               os.Getenv("SMTP_USERNAME"),
 		SMTP_PASSWORD:               os.Getenv("SMTP_PASSWORD"),
 		SMTP_SERVER_ADDRESS:         os.Getenv("SMTP_SERVER_ADDRESS"),
+		SMTP_ENVELOPE_FROM:          os.Getenv("SMTP_ENVELOPE_FROM"),
+		TRANSPORT:                   getTransport(os.Getenv("TRANSPORT")),
+		TRANSPORT_DIRECTORY:         os.Getenv("TRANSPORT_DIRECTORY"),
+		TRANSPORT_COMMAND:           os.Getenv("TRANSPORT_COMMAND"),
+		SMTP_BACKENDS:               getSMTPBackends(os.Getenv("SMTP_BACKENDS")),
+		SMTP_FAILOVER_COOLDOWN:      getDuration(os.Getenv("SMTP_FAILOVER_COOLDOWN"), time.Minute),
+		SMTP_ROUTES:                 getRoutes(os.Getenv("SMTP_ROUTES")),
+		MAIL_FROM_ADDRESS:           os.Getenv("MAIL_FROM_ADDRESS"),
+		MAIL_FROM_NAME:              os.Getenv("MAIL_FROM_NAME"),
+		ALLOWED_FROM_ADDRESSES:      getList(os.Getenv("ALLOWED_FROM_ADDRESSES")),
 		EMAIL_TEMPLATES_DIRECTORY:   os.Getenv("EMAIL_TEMPLATES_DIRECTORY"),
+		TEMPLATE_ACTIVE_VERSIONS:    getKeyValues(os.Getenv("TEMPLATE_ACTIVE_VERSIONS")),
+		ALLOWED_CUSTOM_HEADERS:      getList(os.Getenv("ALLOWED_CUSTOM_HEADERS")),
+		QUEUE_PATH:                  os.Getenv("QUEUE_PATH"),
+		QUEUE_WORKERS:               getInt(os.Getenv("QUEUE_WORKERS"), 1),
+		QUEUE_RETENTION:             getDuration(os.Getenv("QUEUE_RETENTION"), 7*24*time.Hour),
+		RETRY_MAX_ATTEMPTS:          getInt(os.Getenv("RETRY_MAX_ATTEMPTS"), 10),
+		RETRY_MAX_AGE:               getDuration(os.Getenv("RETRY_MAX_AGE"), 72*time.Hour),
+		RETRY_INITIAL_INTERVAL:      getDuration(os.Getenv("RETRY_INITIAL_INTERVAL"), 30*time.Second),
+		RETRY_MAX_INTERVAL:          getDuration(os.Getenv("RETRY_MAX_INTERVAL"), time.Hour),
+		RETRY_MULTIPLIER:            getFloat(os.Getenv("RETRY_MULTIPLIER"), 2),
+		RETRY_JITTER:                getFloat(os.Getenv("RETRY_JITTER"), 0.2),
+		IDEMPOTENCY_WINDOW:          getDuration(os.Getenv("IDEMPOTENCY_WINDOW"), 24*time.Hour),
+		SMTP_RATE_LIMITS:            getLimits(os.Getenv("SMTP_RATE_LIMITS")),
+		DOMAIN_RATE_LIMITS:          getLimits(os.Getenv("DOMAIN_RATE_LIMITS")),
+		DOMAIN_CONCURRENCY:          getInt(os.Getenv("DOMAIN_CONCURRENCY"), 0),
+		DKIM_KEYS:                   getDKIMKeys(os.Getenv("DKIM_KEYS")),
+		SMIME_CERTIFICATE_PATH:      os.Getenv("SMIME_CERTIFICATE_PATH"),
+		SMIME_KEY_PATH:              os.Getenv("SMIME_KEY_PATH"),
+		SMIME_RECIPIENTS_DIRECTORY:  os.Getenv("SMIME_RECIPIENTS_DIRECTORY"),
+		PGP_KEYS_DIRECTORY:          os.Getenv("PGP_KEYS_DIRECTORY"),
+		PGP_SIGNING_KEY_PATH:        os.Getenv("PGP_SIGNING_KEY_PATH"),
+		PGP_SIGNING_KEY_PASSPHRASE:  os.Getenv("PGP_SIGNING_KEY_PASSPHRASE"),
+		PGP_FALLBACK:                os.Getenv("PGP_FALLBACK"),
+		QUOTA_HOURLY:                getInt(os.Getenv("QUOTA_HOURLY"), 0),
+		QUOTA_DAILY:                 getInt(os.Getenv("QUOTA_DAILY"), 0),
+		API_KEYS:                    getAPIKeys("API_KEYS"),
+		ADMIN_API_KEYS:              getAPIKeys("ADMIN_API_KEYS"),
+		TRUSTED_PROXIES:             getList(os.Getenv("TRUSTED_PROXIES")),
+		SUPPRESSION_PATH:            os.Getenv("SUPPRESSION_PATH"),
+		UNSUBSCRIBE_URL:             os.Getenv("UNSUBSCRIBE_URL"),
+		UNSUBSCRIBE_SECRET:          os.Getenv("UNSUBSCRIBE_SECRET"),
+		UNSUBSCRIBE_HTTP_ADDRESS:    os.Getenv("UNSUBSCRIBE_HTTP_ADDRESS"),
+		WEBHOOK_URLS:                getList(os.Getenv("WEBHOOK_URLS")),
+		WEBHOOK_SECRET:              os.Getenv("WEBHOOK_SECRET"),
+		WEBHOOK_EVENTS:              getList(os.Getenv("WEBHOOK_EVENTS")),
+		WEBHOOK_TIMEOUT:             getDuration(os.Getenv("WEBHOOK_TIMEOUT"), 10*time.Second),
+		WEBHOOK_MAX_ATTEMPTS:        getInt(os.Getenv("WEBHOOK_MAX_ATTEMPTS"), 10),
+		WEBHOOK_WORKERS:             getInt(os.Getenv("WEBHOOK_WORKERS"), 4),
+		WEBHOOK_QUEUE_PATH:          os.Getenv("WEBHOOK_QUEUE_PATH"),
 		TLS_CA_CERTIFICATE_PATH:     os.Getenv("TLS_CA_CERTIFICATE_PATH"),
 		TLS_SERVER_CERTIFICATE_PATH: os.Getenv("TLS_SERVER_CERTIFICATE_PATH"),
 		TLS_SERVER_KEY_PATH:         os.Getenv("TLS_SERVER_KEY_PATH"),
@@ -100,25 +444,296 @@ func main() {
 		log.Fatalf("Failed to load templates: %s\n", err.Error())
 	}
 
-	log.Println("Setting up SMTP configuration...")
-	smtpConfig := &smtp.SMTPConfig{
-		Username: env.SMTP_USERNAME,
-		Password: env.SMTP_PASSWORD,
-		Address:  env.SMTP_SERVER_ADDRESS,
+	for group, version := range env.TEMPLATE_ACTIVE_VERSIONS {
+		if err := templates.SetActive(group, version); err != nil {
+			log.Fatalf("Failed to set active template version: %s\n", err.Error())
+		}
+		log.Printf("Using version %s of template group %s\n", version, group)
+	}
+
+	backends := []*relay.Backend{}
+	outbox := transport.NewMemory(memoryTransportLimit)
+
+	if len(env.SMTP_BACKENDS) == 0 {
+
+		log.Printf("Initializing %s transport...\n", env.TRANSPORT)
+		client, err := newTransport(&smtpBackend{
+			name:      "default",
+			transport: env.TRANSPORT,
+			config: &smtp.SMTPConfig{
+				Username: env.SMTP_USERNAME,
+				Password: env.SMTP_PASSWORD,
+				Address:  env.SMTP_SERVER_ADDRESS,
+			},
+			directory: env.TRANSPORT_DIRECTORY,
+			command:   env.TRANSPORT_COMMAND,
+		}, true, outbox)
+		if err != nil {
+			log.Fatalf("Failed to initialize %s transport: %s\n", env.TRANSPORT, err.Error())
+		}
+
+		backends = append(backends, &relay.Backend{
+			Name:      "default",
+			Transport: client,
+		})
+	}
+
+	for _, backend := range env.SMTP_BACKENDS {
+
+		// Backends connect on their first send, so that one provider being
+		// down does not keep the others from being used.
+		log.Printf("Setting up %s backend %s...\n", backend.transport, backend.name)
+		client, err := newTransport(backend, false, outbox)
+		if err != nil {
+			log.Fatalf("Failed to initialize backend %s: %s\n", backend.name, err.Error())
+		}
+
+		backends = append(backends, &relay.Backend{
+			Name:      backend.name,
+			Priority:  backend.priority,
+			Weight:    backend.weight,
+			Transport: client,
+		})
 	}
 
-	log.Println("Initializing SMTP client...")
-	client, err := smtp.New(smtpConfig)
+	// The admin APIs list the captured messages only when a backend keeps
+	// them.
+	usesOutbox := slices.ContainsFunc(backends, func(backend *relay.Backend) bool {
+		return backend.Transport == outbox
+	})
+	if !usesOutbox {
+		outbox = nil
+	}
+
+	smtpRelay, err := relay.New(backends, env.SMTP_FAILOVER_COOLDOWN)
 	if err != nil {
-		log.Fatalf("Failed to initialize SMTP client: %s\n", err.Error())
+		log.Fatalf("Failed to initialize SMTP backends: %s\n", err.Error())
 	}
-	defer client.Close()
+	defer smtpRelay.Close()
+
+	var router *routing.Router
+
+	if len(env.SMTP_ROUTES) > 0 {
+
+		for _, rule := range env.SMTP_ROUTES {
+			for _, backend := range rule.Backends {
+				if !smtpRelay.Has(backend) {
+					log.Fatalf("SMTP_ROUTES refers to unknown SMTP backend %s\n", backend)
+				}
+			}
+		}
+
+		names := make([]string, 0, len(backends))
+		for _, backend := range backends {
+			names = append(names, backend.Name)
+		}
+
+		router, err = routing.New(env.SMTP_ROUTES, names)
+		if err != nil {
+			log.Fatalf("Invalid SMTP_ROUTES setting: %s\n", err.Error())
+		}
+	}
+
+	var webhooks *webhook.Webhooks
+
+	if len(env.WEBHOOK_URLS) > 0 {
+
+		if env.WEBHOOK_SECRET == "" {
+			log.Fatalln("WEBHOOK_SECRET is required when WEBHOOK_URLS is set")
+		}
+
+		if env.WEBHOOK_QUEUE_PATH == "" {
+			env.WEBHOOK_QUEUE_PATH = "./webhooks.db"
+		}
+
+		log.Printf("Opening webhook queue %s...\n", env.WEBHOOK_QUEUE_PATH)
+		webhooks, err = webhook.New(env.WEBHOOK_QUEUE_PATH, &webhook.Config{
+			URLs:     env.WEBHOOK_URLS,
+			Secret:   env.WEBHOOK_SECRET,
+			Statuses: env.WEBHOOK_EVENTS,
+			Timeout:  env.WEBHOOK_TIMEOUT,
+			Workers:  env.WEBHOOK_WORKERS,
+			Retry: &queue.RetryPolicy{
+				MaxAttempts:     env.WEBHOOK_MAX_ATTEMPTS,
+				InitialInterval: 10 * time.Second,
+				MaxInterval:     10 * time.Minute,
+				Multiplier:      2,
+				Jitter:          0.2,
+			},
+		})
+		if err != nil {
+			log.Fatalf("Failed to open webhook queue: %s\n", err.Error())
+		}
+		defer webhooks.Close()
+
+		webhooks.Start()
+	}
+
+	if env.SUPPRESSION_PATH == "" {
+		env.SUPPRESSION_PATH = "./suppression.db"
+	}
+
+	log.Printf("Opening suppression list %s...\n", env.SUPPRESSION_PATH)
+	suppressions, err := suppression.New(env.SUPPRESSION_PATH)
+	if err != nil {
+		log.Fatalf("Failed to open suppression list: %s\n", err.Error())
+	}
+	defer suppressions.Close()
+
+	if env.QUEUE_PATH == "" {
+		env.QUEUE_PATH = "./queue.db"
+	}
+
+	log.Printf("Opening message queue %s...\n", env.QUEUE_PATH)
+	messageQueue, err := queue.New(env.QUEUE_PATH, &queue.RetryPolicy{
+		MaxAttempts:     env.RETRY_MAX_ATTEMPTS,
+		MaxAge:          env.RETRY_MAX_AGE,
+		InitialInterval: env.RETRY_INITIAL_INTERVAL,
+		MaxInterval:     env.RETRY_MAX_INTERVAL,
+		Multiplier:      env.RETRY_MULTIPLIER,
+		Jitter:          env.RETRY_JITTER,
+	}, env.QUEUE_RETENTION)
+	if err != nil {
+		log.Fatalf("Failed to open message queue: %s\n", err.Error())
+	}
+	defer messageQueue.Close()
+
+	var unsubscribeSigner *unsubscribe.Signer
+
+	if env.UNSUBSCRIBE_URL != "" {
+
+		if env.UNSUBSCRIBE_SECRET == "" {
+			log.Fatalln("UNSUBSCRIBE_SECRET is required when UNSUBSCRIBE_URL is set")
+		}
+
+		// Mailbox providers have no client certificate to follow the links.
+		if tlsConfig != nil && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert && env.UNSUBSCRIBE_HTTP_ADDRESS == "" {
+			log.Fatalln("UNSUBSCRIBE_HTTP_ADDRESS is required when UNSUBSCRIBE_URL is set and TLS_CA_CERTIFICATE_PATH requires client certificates")
+		}
+
+		signer, err := unsubscribe.New(env.UNSUBSCRIBE_URL, env.UNSUBSCRIBE_SECRET)
+		if err != nil {
+			log.Fatalf("Invalid UNSUBSCRIBE_URL: %s\n", err.Error())
+		}
+
+		unsubscribeSigner = signer
+	}
+
+	var dkimSigner *dkim.Signer
+
+	if len(env.DKIM_KEYS) > 0 {
+		dkimSigner = dkim.New(env.DKIM_KEYS)
+	}
+
+	var smimeSealer *smime.SMIME
+
+	if isTLSConfigured(env.SMIME_CERTIFICATE_PATH, env.SMIME_KEY_PATH) || env.SMIME_RECIPIENTS_DIRECTORY != "" {
+
+		cfg := &smime.Config{
+			RecipientsDirectory: env.SMIME_RECIPIENTS_DIRECTORY,
+		}
+
+		if isTLSConfigured(env.SMIME_CERTIFICATE_PATH, env.SMIME_KEY_PATH) {
+			cert, err := tls.LoadX509KeyPair(env.SMIME_CERTIFICATE_PATH, env.SMIME_KEY_PATH)
+			if err != nil {
+				log.Fatalf("Failed to load S/MIME certificate: %s\n", err.Error())
+			}
+			cfg.Certificate = &cert
+		}
+
+		smimeSealer, err = smime.New(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize S/MIME: %s\n", err.Error())
+		}
+	}
+
+	var pgpKeys *pgp.KeyStore
+	var pgpSealer *pgp.PGP
+
+	if env.PGP_KEYS_DIRECTORY != "" {
+
+		pgpKeys, err = pgp.NewKeyStore(env.PGP_KEYS_DIRECTORY)
+		if err != nil {
+			log.Fatalf("Failed to open OpenPGP key store: %s\n", err.Error())
+		}
+
+		if env.PGP_FALLBACK == "" {
+			env.PGP_FALLBACK = pgp.FallbackFail
+		}
+
+		cfg := &pgp.Config{
+			Keys:     pgpKeys,
+			Fallback: env.PGP_FALLBACK,
+		}
+
+		if env.PGP_SIGNING_KEY_PATH != "" {
+			cfg.Signer, err = pgp.LoadSigner(env.PGP_SIGNING_KEY_PATH, env.PGP_SIGNING_KEY_PASSPHRASE)
+			if err != nil {
+				log.Fatalf("Failed to load OpenPGP signing key: %s\n", err.Error())
+			}
+		}
+
+		pgpSealer, err = pgp.New(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize OpenPGP: %s\n", err.Error())
+		}
+	}
+
+	var limiter *ratelimit.Limiter
+
+	if len(env.SMTP_RATE_LIMITS) > 0 || len(env.DOMAIN_RATE_LIMITS) > 0 || env.DOMAIN_CONCURRENCY > 0 {
+		limiter = ratelimit.New(&ratelimit.Config{
+			Account:           env.SMTP_RATE_LIMITS,
+			Domain:            env.DOMAIN_RATE_LIMITS,
+			DomainConcurrency: env.DOMAIN_CONCURRENCY,
+		})
+	}
+
+	var clientQuota *quota.Quota
+
+	if env.QUOTA_HOURLY > 0 || env.QUOTA_DAILY > 0 {
+		clientQuota = quota.New(&quota.Config{
+			Hourly: env.QUOTA_HOURLY,
+			Daily:  env.QUOTA_DAILY,
+		})
+	}
+
+	service, err := mailer.New(smtpRelay, templates, messageQueue, &mailer.Config{
+		AllowedHeaders: env.ALLOWED_CUSTOM_HEADERS,
+		From: &mail.Address{
+			Name:    env.MAIL_FROM_NAME,
+			Address: env.MAIL_FROM_ADDRESS,
+		},
+		AllowedFrom:       env.ALLOWED_FROM_ADDRESSES,
+		EnvelopeFrom:      env.SMTP_ENVELOPE_FROM,
+		IdempotencyWindow: env.IDEMPOTENCY_WINDOW,
+		Limiter:           limiter,
+		Quota:             clientQuota,
+		Suppressions:      suppressions,
+		Unsubscribe:       unsubscribeSigner,
+		Router:            router,
+		SMIME:             smimeSealer,
+		PGP:               pgpSealer,
+		DKIM:              dkimSigner,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer, set MAIL_FROM_ADDRESS: %s\n", err.Error())
+	}
+
+	if webhooks != nil {
+		messageQueue.Subscribe(webhooks.Publish)
+	}
+
+	apiKeys := auth.New(env.API_KEYS, env.ADMIN_API_KEYS)
+
+	log.Printf("Starting %d queue workers...\n", env.QUEUE_WORKERS)
+	messageQueue.Start(env.QUEUE_WORKERS, service.Deliver)
 
 	if _, ok := env.ENABLED_LISTENERS["grpc"]; ok {
 
 		log.Println("Creating gRPC listener service...")
 
-		app, err := grpclistener.New(client, templates, tlsConfig)
+		app, err := grpclistener.New(service, messageQueue, suppressions, pgpKeys, outbox, apiKeys, tlsConfig)
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
@@ -142,7 +757,10 @@ func main() {
 
 		log.Println("Creating HTTPS listener service...")
 
-		app := httplistener.New(client, templates)
+		app, err := httplistener.New(service, messageQueue, suppressions, pgpKeys, outbox, apiKeys, env.TRUSTED_PROXIES)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES setting: %s\n", err.Error())
+		}
 		defer app.Stop()
 
 		go func() {
@@ -177,6 +795,25 @@ func main() {
 
 		}()
 
+		if env.UNSUBSCRIBE_HTTP_ADDRESS != "" {
+			go func() {
+
+				var unsubscribeTLSConfig *tls.Config
+				if tlsConfig != nil {
+					unsubscribeTLSConfig = tlsConfig.Clone()
+					unsubscribeTLSConfig.ClientAuth = tls.NoClientCert
+					unsubscribeTLSConfig.ClientCAs = nil
+				}
+
+				log.Printf("Starting unsubscribe listener on %s...\n", env.UNSUBSCRIBE_HTTP_ADDRESS)
+
+				err := app.RunUnsubscribe(env.UNSUBSCRIBE_HTTP_ADDRESS, unsubscribeTLSConfig)
+				if err != nil {
+					life.Exitf("Unsubscribe listener exited with error: %s\n", err.Error())
+				}
+			}()
+		}
+
 	}
 
 	log.Println("Waiting for shutdown signal...")
@@ -7,9 +7,12 @@ toolchain go1.23.8
 require google.golang.org/grpc v1.71.1
 
 require (
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/gin-gonic/gin v1.10.0
 	github.com/lucap9056/go-lifecycle v1.0.0
 	github.com/stretchr/testify v1.10.0
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.36.0
 	golang.org/x/net v0.38.0
 	google.golang.org/protobuf v1.36.4
 )
@@ -17,6 +20,7 @@ require (
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
 	github.com/bytedance/sonic/loader v0.1.1 // indirect
+	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/cloudwego/base64x v0.1.4 // indirect
 	github.com/cloudwego/iasm v0.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -37,7 +41,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
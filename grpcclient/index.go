@@ -3,10 +3,13 @@ package grpcclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/lucap9056/mail-template-sender/grpcstruct"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Client struct {
@@ -30,33 +33,368 @@ func New(target string, opts ...grpc.DialOption) (*Client, error) {
 	return client, nil
 }
 
+// WithAPIKey returns a dial option sending key in the x-api-key metadata of
+// every call. The server uses it to identify the client for its quota and
+// rejects unknown keys.
+func WithAPIKey(key string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(apiKey(key))
+}
+
+type apiKey string
+
+func (key apiKey) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": string(key)}, nil
+}
+
+func (key apiKey) RequireTransportSecurity() bool {
+	return false
+}
+
 type MailTemplateOptions[T any] struct {
-	TemplateGroup string
-	TemplateNames []string
-	To            []string
-	Data          T
+	TemplateGroup   string
+	TemplateVersion string
+	TemplateNames   []string
+	From            string
+	To              []string
+	Cc              []string
+	Bcc             []string
+	ReplyTo         string
+	Headers         map[string]string
+	Data            T
+	Recipients      []Recipient[T]
+	// SendAt delays the delivery until the given time when set.
+	SendAt time.Time
+	// IdempotencyKey makes retries of the same request safe. The server
+	// returns the original result for a key it has already seen.
+	IdempotencyKey string
+	// Tag labels the request for the routing rules of the server.
+	Tag string
+}
+
+// Recipient is a mail merge entry. Each recipient receives an individual
+// message rendered with its own data.
+type Recipient[T any] struct {
+	Address string
+	Data    T
+}
+
+type MailTemplateResult struct {
+	MessageID       string
+	TemplateVersion string
+	Variant         string
+	Results         []RecipientResult
+}
+
+type RecipientResult struct {
+	Address         string
+	Ok              bool
+	MessageID       string
+	TemplateVersion string
+	Variant         string
+	Error           string
 }
 
 func (c *Client) Send(ctx context.Context, options *MailTemplateOptions[any]) error {
+	_, err := c.SendWithResult(ctx, options)
+	return err
+}
 
-	dataJson, err := json.Marshal(options.Data)
+// SendWithResult sends a message like Send and returns the id and template
+// version of the queued message, or the result of each recipient.
+func (c *Client) SendWithResult(ctx context.Context, options *MailTemplateOptions[any]) (*MailTemplateResult, error) {
+
+	req, err := toRequest(options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
+	res, err := c.client.Send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return toResult(res), nil
+}
+
+// BatchResult is the outcome of one message of a batch. Index is the
+// position of the message in the batch.
+type BatchResult struct {
+	Index  int
+	Ok     bool
+	Error  string
+	Result *MailTemplateResult
+}
+
+// SendBatch streams all messages to the server in a single call and returns
+// one result per message.
+func (c *Client) SendBatch(ctx context.Context, messages []MailTemplateOptions[any]) ([]BatchResult, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	stream, err := c.client.SendBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+
+		req, err := toRequest(&messages[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, 0, len(res.Results))
+
+	for _, item := range res.Results {
+
+		result := BatchResult{
+			Index: int(item.Index),
+			Ok:    item.Ok,
+			Error: item.Error,
+		}
+
+		if item.Response != nil {
+			result.Result = toResult(item.Response)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func toRequest(options *MailTemplateOptions[any]) (*grpcstruct.MailTemplateRequest, error) {
+
+	dataJson, err := json.Marshal(options.Data)
+	if err != nil {
+		return nil, err
+	}
+
 	req := &grpcstruct.MailTemplateRequest{
-		TemplateGroup: options.TemplateGroup,
-		TemplateNames: options.TemplateNames,
-		To:            options.To,
-		DataJson:      dataJson,
+		TemplateGroup:   options.TemplateGroup,
+		TemplateVersion: options.TemplateVersion,
+		TemplateNames:   options.TemplateNames,
+		From:            options.From,
+		To:              options.To,
+		Cc:              options.Cc,
+		Bcc:             options.Bcc,
+		ReplyTo:         options.ReplyTo,
+		Headers:         options.Headers,
+		DataJson:        dataJson,
+		IdempotencyKey:  options.IdempotencyKey,
+		Tag:             options.Tag,
+	}
+
+	if !options.SendAt.IsZero() {
+		req.SendAt = timestamppb.New(options.SendAt)
 	}
 
-	_, err = c.client.Send(ctx, req)
+	for _, recipient := range options.Recipients {
 
-	return err
+		dataJson, err := json.Marshal(recipient.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Recipients = append(req.Recipients, &grpcstruct.Recipient{
+			Address:  recipient.Address,
+			DataJson: dataJson,
+		})
+	}
+
+	return req, nil
+}
+
+func toResult(res *grpcstruct.MailTemplateResponse) *MailTemplateResult {
+
+	result := &MailTemplateResult{
+		MessageID:       res.MessageId,
+		TemplateVersion: res.TemplateVersion,
+		Variant:         res.Variant,
+	}
+
+	for _, recipientResult := range res.Results {
+		result.Results = append(result.Results, RecipientResult{
+			Address:         recipientResult.Address,
+			Ok:              recipientResult.Ok,
+			MessageID:       recipientResult.MessageId,
+			TemplateVersion: recipientResult.TemplateVersion,
+			Variant:         recipientResult.Variant,
+			Error:           recipientResult.Error,
+		})
+	}
+
+	return result
+}
+
+// Message describes the delivery status of a message.
+type Message struct {
+	ID              string
+	Status          string
+	TemplateGroup   string
+	TemplateVersion string
+	Variant         string
+	Backend         string
+	From            string
+	To              []string
+	Error           string
+	Attempts        []Attempt
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DueAt           time.Time
+}
+
+type Attempt struct {
+	At      time.Time
+	Backend string
+	Code    int
+	Error   string
+}
+
+// GetStatus returns the delivery status of a message.
+func (c *Client) GetStatus(ctx context.Context, id string) (*Message, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := c.client.GetStatus(ctx, &grpcstruct.MessageRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(res), nil
+}
+
+// Cancel withdraws a message that has not been sent yet.
+func (c *Client) Cancel(ctx context.Context, id string) (*Message, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	res, err := c.client.Cancel(ctx, &grpcstruct.MessageRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(res), nil
+}
+
+// Reschedule moves the delivery of a message that has not been sent yet.
+func (c *Client) Reschedule(ctx context.Context, id string, sendAt time.Time) (*Message, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	req := &grpcstruct.RescheduleRequest{
+		Id:     id,
+		SendAt: timestamppb.New(sendAt),
+	}
+
+	res, err := c.client.Reschedule(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(res), nil
+}
+
+func toMessage(res *grpcstruct.Message) *Message {
+
+	msg := &Message{
+		ID:              res.Id,
+		Status:          res.Status,
+		TemplateGroup:   res.TemplateGroup,
+		TemplateVersion: res.TemplateVersion,
+		Variant:         res.Variant,
+		Backend:         res.Backend,
+		From:            res.From,
+		To:              res.To,
+		Error:           res.Error,
+		CreatedAt:       res.CreatedAt.AsTime(),
+		UpdatedAt:       res.UpdatedAt.AsTime(),
+		DueAt:           res.DueAt.AsTime(),
+	}
+
+	for _, attempt := range res.Attempts {
+		msg.Attempts = append(msg.Attempts, Attempt{
+			At:      attempt.At.AsTime(),
+			Backend: attempt.Backend,
+			Code:    int(attempt.Code),
+			Error:   attempt.Error,
+		})
+	}
+
+	return msg
+}
+
+// EventFilter selects the events of WatchEvents. Empty fields match every
+// event.
+type EventFilter struct {
+	TemplateGroup string
+	MessageID     string
+	Recipient     string
+}
+
+// Event is a status change of a message.
+type Event struct {
+	MessageID     string
+	Status        string
+	TemplateGroup string
+	To            []string
+	Code          int
+	Error         string
+	At            time.Time
+}
+
+// WatchEvents calls fn with every event matching filter until ctx is done,
+// the stream ends or fn returns an error.
+func (c *Client) WatchEvents(ctx context.Context, filter *EventFilter, fn func(event *Event) error) error {
+
+	stream, err := c.client.WatchEvents(ctx, &grpcstruct.WatchEventsRequest{
+		TemplateGroup: filter.TemplateGroup,
+		MessageId:     filter.MessageID,
+		Recipient:     filter.Recipient,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(&Event{
+			MessageID:     res.MessageId,
+			Status:        res.Status,
+			TemplateGroup: res.TemplateGroup,
+			To:            res.To,
+			Code:          int(res.Code),
+			Error:         res.Error,
+			At:            res.At.AsTime(),
+		})
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (c *Client) Close() error {
@@ -19,7 +19,22 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MailTemplate_Send_FullMethodName = "/grpcstruct.MailTemplate/Send"
+	MailTemplate_Send_FullMethodName              = "/grpcstruct.MailTemplate/Send"
+	MailTemplate_SendBatch_FullMethodName         = "/grpcstruct.MailTemplate/SendBatch"
+	MailTemplate_ListDeadLetters_FullMethodName   = "/grpcstruct.MailTemplate/ListDeadLetters"
+	MailTemplate_GetDeadLetter_FullMethodName     = "/grpcstruct.MailTemplate/GetDeadLetter"
+	MailTemplate_RequeueDeadLetter_FullMethodName = "/grpcstruct.MailTemplate/RequeueDeadLetter"
+	MailTemplate_GetStatus_FullMethodName         = "/grpcstruct.MailTemplate/GetStatus"
+	MailTemplate_Cancel_FullMethodName            = "/grpcstruct.MailTemplate/Cancel"
+	MailTemplate_Reschedule_FullMethodName        = "/grpcstruct.MailTemplate/Reschedule"
+	MailTemplate_WatchEvents_FullMethodName       = "/grpcstruct.MailTemplate/WatchEvents"
+	MailTemplate_ListSuppressions_FullMethodName  = "/grpcstruct.MailTemplate/ListSuppressions"
+	MailTemplate_AddSuppression_FullMethodName    = "/grpcstruct.MailTemplate/AddSuppression"
+	MailTemplate_RemoveSuppression_FullMethodName = "/grpcstruct.MailTemplate/RemoveSuppression"
+	MailTemplate_GetPGPKey_FullMethodName         = "/grpcstruct.MailTemplate/GetPGPKey"
+	MailTemplate_PutPGPKey_FullMethodName         = "/grpcstruct.MailTemplate/PutPGPKey"
+	MailTemplate_DeletePGPKey_FullMethodName      = "/grpcstruct.MailTemplate/DeletePGPKey"
+	MailTemplate_ListOutbox_FullMethodName        = "/grpcstruct.MailTemplate/ListOutbox"
 )
 
 // MailTemplateClient is the client API for MailTemplate service.
@@ -27,6 +42,21 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MailTemplateClient interface {
 	Send(ctx context.Context, in *MailTemplateRequest, opts ...grpc.CallOption) (*MailTemplateResponse, error)
+	SendBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[MailTemplateRequest, BatchResponse], error)
+	ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error)
+	GetDeadLetter(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error)
+	RequeueDeadLetter(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error)
+	GetStatus(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error)
+	Cancel(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error)
+	Reschedule(ctx context.Context, in *RescheduleRequest, opts ...grpc.CallOption) (*Message, error)
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	ListSuppressions(ctx context.Context, in *ListSuppressionsRequest, opts ...grpc.CallOption) (*ListSuppressionsResponse, error)
+	AddSuppression(ctx context.Context, in *Suppression, opts ...grpc.CallOption) (*Suppression, error)
+	RemoveSuppression(ctx context.Context, in *RemoveSuppressionRequest, opts ...grpc.CallOption) (*Suppression, error)
+	GetPGPKey(ctx context.Context, in *PGPKeyRequest, opts ...grpc.CallOption) (*PGPKey, error)
+	PutPGPKey(ctx context.Context, in *PGPKey, opts ...grpc.CallOption) (*PGPKey, error)
+	DeletePGPKey(ctx context.Context, in *PGPKeyRequest, opts ...grpc.CallOption) (*PGPKey, error)
+	ListOutbox(ctx context.Context, in *ListOutboxRequest, opts ...grpc.CallOption) (*ListOutboxResponse, error)
 }
 
 type mailTemplateClient struct {
@@ -47,11 +77,188 @@ func (c *mailTemplateClient) Send(ctx context.Context, in *MailTemplateRequest,
 	return out, nil
 }
 
+func (c *mailTemplateClient) SendBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[MailTemplateRequest, BatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MailTemplate_ServiceDesc.Streams[0], MailTemplate_SendBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MailTemplateRequest, BatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MailTemplate_SendBatchClient = grpc.ClientStreamingClient[MailTemplateRequest, BatchResponse]
+
+func (c *mailTemplateClient) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDeadLettersResponse)
+	err := c.cc.Invoke(ctx, MailTemplate_ListDeadLetters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) GetDeadLetter(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, MailTemplate_GetDeadLetter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) RequeueDeadLetter(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, MailTemplate_RequeueDeadLetter_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) GetStatus(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, MailTemplate_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) Cancel(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, MailTemplate_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) Reschedule(ctx context.Context, in *RescheduleRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, MailTemplate_Reschedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MailTemplate_ServiceDesc.Streams[1], MailTemplate_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MailTemplate_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+func (c *mailTemplateClient) ListSuppressions(ctx context.Context, in *ListSuppressionsRequest, opts ...grpc.CallOption) (*ListSuppressionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSuppressionsResponse)
+	err := c.cc.Invoke(ctx, MailTemplate_ListSuppressions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) AddSuppression(ctx context.Context, in *Suppression, opts ...grpc.CallOption) (*Suppression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Suppression)
+	err := c.cc.Invoke(ctx, MailTemplate_AddSuppression_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) RemoveSuppression(ctx context.Context, in *RemoveSuppressionRequest, opts ...grpc.CallOption) (*Suppression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Suppression)
+	err := c.cc.Invoke(ctx, MailTemplate_RemoveSuppression_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) GetPGPKey(ctx context.Context, in *PGPKeyRequest, opts ...grpc.CallOption) (*PGPKey, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PGPKey)
+	err := c.cc.Invoke(ctx, MailTemplate_GetPGPKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) PutPGPKey(ctx context.Context, in *PGPKey, opts ...grpc.CallOption) (*PGPKey, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PGPKey)
+	err := c.cc.Invoke(ctx, MailTemplate_PutPGPKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) DeletePGPKey(ctx context.Context, in *PGPKeyRequest, opts ...grpc.CallOption) (*PGPKey, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PGPKey)
+	err := c.cc.Invoke(ctx, MailTemplate_DeletePGPKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mailTemplateClient) ListOutbox(ctx context.Context, in *ListOutboxRequest, opts ...grpc.CallOption) (*ListOutboxResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListOutboxResponse)
+	err := c.cc.Invoke(ctx, MailTemplate_ListOutbox_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MailTemplateServer is the server API for MailTemplate service.
 // All implementations must embed UnimplementedMailTemplateServer
 // for forward compatibility.
 type MailTemplateServer interface {
 	Send(context.Context, *MailTemplateRequest) (*MailTemplateResponse, error)
+	SendBatch(grpc.ClientStreamingServer[MailTemplateRequest, BatchResponse]) error
+	ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error)
+	GetDeadLetter(context.Context, *MessageRequest) (*Message, error)
+	RequeueDeadLetter(context.Context, *MessageRequest) (*Message, error)
+	GetStatus(context.Context, *MessageRequest) (*Message, error)
+	Cancel(context.Context, *MessageRequest) (*Message, error)
+	Reschedule(context.Context, *RescheduleRequest) (*Message, error)
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error
+	ListSuppressions(context.Context, *ListSuppressionsRequest) (*ListSuppressionsResponse, error)
+	AddSuppression(context.Context, *Suppression) (*Suppression, error)
+	RemoveSuppression(context.Context, *RemoveSuppressionRequest) (*Suppression, error)
+	GetPGPKey(context.Context, *PGPKeyRequest) (*PGPKey, error)
+	PutPGPKey(context.Context, *PGPKey) (*PGPKey, error)
+	DeletePGPKey(context.Context, *PGPKeyRequest) (*PGPKey, error)
+	ListOutbox(context.Context, *ListOutboxRequest) (*ListOutboxResponse, error)
 	mustEmbedUnimplementedMailTemplateServer()
 }
 
@@ -65,6 +272,51 @@ type UnimplementedMailTemplateServer struct{}
 func (UnimplementedMailTemplateServer) Send(context.Context, *MailTemplateRequest) (*MailTemplateResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Send not implemented")
 }
+func (UnimplementedMailTemplateServer) SendBatch(grpc.ClientStreamingServer[MailTemplateRequest, BatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method SendBatch not implemented")
+}
+func (UnimplementedMailTemplateServer) ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedMailTemplateServer) GetDeadLetter(context.Context, *MessageRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeadLetter not implemented")
+}
+func (UnimplementedMailTemplateServer) RequeueDeadLetter(context.Context, *MessageRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequeueDeadLetter not implemented")
+}
+func (UnimplementedMailTemplateServer) GetStatus(context.Context, *MessageRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedMailTemplateServer) Cancel(context.Context, *MessageRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedMailTemplateServer) Reschedule(context.Context, *RescheduleRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reschedule not implemented")
+}
+func (UnimplementedMailTemplateServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedMailTemplateServer) ListSuppressions(context.Context, *ListSuppressionsRequest) (*ListSuppressionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSuppressions not implemented")
+}
+func (UnimplementedMailTemplateServer) AddSuppression(context.Context, *Suppression) (*Suppression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddSuppression not implemented")
+}
+func (UnimplementedMailTemplateServer) RemoveSuppression(context.Context, *RemoveSuppressionRequest) (*Suppression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveSuppression not implemented")
+}
+func (UnimplementedMailTemplateServer) GetPGPKey(context.Context, *PGPKeyRequest) (*PGPKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPGPKey not implemented")
+}
+func (UnimplementedMailTemplateServer) PutPGPKey(context.Context, *PGPKey) (*PGPKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutPGPKey not implemented")
+}
+func (UnimplementedMailTemplateServer) DeletePGPKey(context.Context, *PGPKeyRequest) (*PGPKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePGPKey not implemented")
+}
+func (UnimplementedMailTemplateServer) ListOutbox(context.Context, *ListOutboxRequest) (*ListOutboxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOutbox not implemented")
+}
 func (UnimplementedMailTemplateServer) mustEmbedUnimplementedMailTemplateServer() {}
 func (UnimplementedMailTemplateServer) testEmbeddedByValue()                      {}
 
@@ -104,6 +356,258 @@ func _MailTemplate_Send_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MailTemplate_SendBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MailTemplateServer).SendBatch(&grpc.GenericServerStream[MailTemplateRequest, BatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MailTemplate_SendBatchServer = grpc.ClientStreamingServer[MailTemplateRequest, BatchResponse]
+
+func _MailTemplate_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_ListDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).ListDeadLetters(ctx, req.(*ListDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_GetDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).GetDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_GetDeadLetter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).GetDeadLetter(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_RequeueDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).RequeueDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_RequeueDeadLetter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).RequeueDeadLetter(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).GetStatus(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).Cancel(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_Reschedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RescheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).Reschedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_Reschedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).Reschedule(ctx, req.(*RescheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MailTemplateServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MailTemplate_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+func _MailTemplate_ListSuppressions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSuppressionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).ListSuppressions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_ListSuppressions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).ListSuppressions(ctx, req.(*ListSuppressionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_AddSuppression_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Suppression)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).AddSuppression(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_AddSuppression_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).AddSuppression(ctx, req.(*Suppression))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_RemoveSuppression_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveSuppressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).RemoveSuppression(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_RemoveSuppression_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).RemoveSuppression(ctx, req.(*RemoveSuppressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_GetPGPKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PGPKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).GetPGPKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_GetPGPKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).GetPGPKey(ctx, req.(*PGPKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_PutPGPKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PGPKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).PutPGPKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_PutPGPKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).PutPGPKey(ctx, req.(*PGPKey))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_DeletePGPKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PGPKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).DeletePGPKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_DeletePGPKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).DeletePGPKey(ctx, req.(*PGPKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MailTemplate_ListOutbox_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOutboxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MailTemplateServer).ListOutbox(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MailTemplate_ListOutbox_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MailTemplateServer).ListOutbox(ctx, req.(*ListOutboxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MailTemplate_ServiceDesc is the grpc.ServiceDesc for MailTemplate service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +619,70 @@ var MailTemplate_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Send",
 			Handler:    _MailTemplate_Send_Handler,
 		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _MailTemplate_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "GetDeadLetter",
+			Handler:    _MailTemplate_GetDeadLetter_Handler,
+		},
+		{
+			MethodName: "RequeueDeadLetter",
+			Handler:    _MailTemplate_RequeueDeadLetter_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _MailTemplate_GetStatus_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _MailTemplate_Cancel_Handler,
+		},
+		{
+			MethodName: "Reschedule",
+			Handler:    _MailTemplate_Reschedule_Handler,
+		},
+		{
+			MethodName: "ListSuppressions",
+			Handler:    _MailTemplate_ListSuppressions_Handler,
+		},
+		{
+			MethodName: "AddSuppression",
+			Handler:    _MailTemplate_AddSuppression_Handler,
+		},
+		{
+			MethodName: "RemoveSuppression",
+			Handler:    _MailTemplate_RemoveSuppression_Handler,
+		},
+		{
+			MethodName: "GetPGPKey",
+			Handler:    _MailTemplate_GetPGPKey_Handler,
+		},
+		{
+			MethodName: "PutPGPKey",
+			Handler:    _MailTemplate_PutPGPKey_Handler,
+		},
+		{
+			MethodName: "DeletePGPKey",
+			Handler:    _MailTemplate_DeletePGPKey_Handler,
+		},
+		{
+			MethodName: "ListOutbox",
+			Handler:    _MailTemplate_ListOutbox_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendBatch",
+			Handler:       _MailTemplate_SendBatch_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _MailTemplate_WatchEvents_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "grpcstruct/grpcstruct.proto",
 }
@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 type Client struct {
 	target string
 	client *http.Client
+	apiKey string
 }
 
 func New(target string, tlsConfig *tls.Config) *Client {
@@ -30,23 +32,226 @@ func New(target string, tlsConfig *tls.Config) *Client {
 		},
 	}
 
-	return &Client{target, client}
+	return &Client{target: target, client: client}
+}
+
+// SetAPIKey sends key in the X-API-Key header of every request. The server
+// uses it to identify the client for its quota and rejects unknown keys.
+func (c *Client) SetAPIKey(key string) {
+	c.apiKey = key
 }
 
 type MailTemplateOptions[T any] struct {
-	TemplateGroup string   `json:"template_group"`
-	TemplateNames []string `json:"template_name"`
-	Targets       []string `json:"targets"`
-	Data          T        `json:"data"`
+	TemplateGroup   string            `json:"template_group"`
+	TemplateVersion string            `json:"template_version,omitempty"`
+	TemplateNames   []string          `json:"template_name"`
+	From            string            `json:"from,omitempty"`
+	Targets         []string          `json:"targets"`
+	Cc              []string          `json:"cc,omitempty"`
+	Bcc             []string          `json:"bcc,omitempty"`
+	ReplyTo         string            `json:"reply_to,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Data            T                 `json:"data"`
+	Recipients      []Recipient[T]    `json:"recipients,omitempty"`
+	SendAt          *time.Time        `json:"send_at,omitempty"`
+	IdempotencyKey  string            `json:"idempotency_key,omitempty"`
+	Tag             string            `json:"tag,omitempty"`
+}
+
+// Recipient is a mail merge entry. Each recipient receives an individual
+// message rendered with its own data.
+type Recipient[T any] struct {
+	Address string `json:"address"`
+	Data    T      `json:"data"`
+}
+
+type MailTemplateResult struct {
+	MessageID       string            `json:"message_id,omitempty"`
+	TemplateVersion string            `json:"template_version,omitempty"`
+	Variant         string            `json:"variant,omitempty"`
+	Results         []RecipientResult `json:"results,omitempty"`
+}
+
+type RecipientResult struct {
+	Address         string `json:"address"`
+	Ok              bool   `json:"ok"`
+	MessageID       string `json:"message_id,omitempty"`
+	TemplateVersion string `json:"template_version,omitempty"`
+	Variant         string `json:"variant,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// BatchOptions is the body of a batch request.
+type BatchOptions[T any] struct {
+	Messages []MailTemplateOptions[T] `json:"messages" binding:"required"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one message of a batch. Index is the
+// position of the message in the batch.
+type BatchResult struct {
+	Index  int                 `json:"index"`
+	Ok     bool                `json:"ok"`
+	Error  string              `json:"error,omitempty"`
+	Result *MailTemplateResult `json:"result,omitempty"`
+}
+
+type RescheduleOptions struct {
+	SendAt time.Time `json:"send_at" binding:"required"`
+}
+
+// Message describes a queued message as reported by the admin and status
+// endpoints.
+type Message struct {
+	ID              string    `json:"id"`
+	Status          string    `json:"status"`
+	TemplateGroup   string    `json:"template_group"`
+	TemplateVersion string    `json:"template_version"`
+	Variant         string    `json:"variant,omitempty"`
+	Backend         string    `json:"backend,omitempty"`
+	From            string    `json:"from,omitempty"`
+	To              []string  `json:"to"`
+	Error           string    `json:"error,omitempty"`
+	Attempts        []Attempt `json:"attempts"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	DueAt           time.Time `json:"due_at"`
+	Data            string    `json:"data,omitempty"`
+}
+
+// Suppression stops the delivery to an address. An empty scope applies to
+// every template group, otherwise to the groups with that name or category.
+type Suppression struct {
+	Address   string    `json:"address" binding:"required"`
+	Scope     string    `json:"scope"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PGPKey is the OpenPGP public key of a recipient, in armored form.
+type PGPKey struct {
+	Address     string `json:"address"`
+	Fingerprint string `json:"fingerprint"`
+	Key         string `json:"key" binding:"required"`
+}
+
+// CapturedMessage is a message kept by the memory transport instead of being
+// delivered.
+type CapturedMessage struct {
+	From string    `json:"from"`
+	To   []string  `json:"to"`
+	Data string    `json:"data"`
+	At   time.Time `json:"at"`
+}
+
+type Attempt struct {
+	At      time.Time `json:"at"`
+	Backend string    `json:"backend,omitempty"`
+	Code    int       `json:"code"`
+	Error   string    `json:"error,omitempty"`
 }
 
 func (c *Client) Send(ctx context.Context, options *MailTemplateOptions[any]) error {
-	msg, err := json.Marshal(options)
+	_, err := c.SendWithResult(ctx, options)
+	return err
+}
+
+// SendWithResult sends a message like Send and returns the id and template
+// version of the queued message, or the result of each recipient.
+func (c *Client) SendWithResult(ctx context.Context, options *MailTemplateOptions[any]) (*MailTemplateResult, error) {
+
+	result := &MailTemplateResult{}
+	if err := c.do(ctx, http.MethodPost, c.target, options, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SendBatch sends many messages in a single request and returns one result
+// per message.
+func (c *Client) SendBatch(ctx context.Context, messages []MailTemplateOptions[any]) ([]BatchResult, error) {
+
+	target, err := url.JoinPath(c.target, "batch")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchResponse{}
+	if err := c.do(ctx, http.MethodPost, target, &BatchOptions[any]{Messages: messages}, result); err != nil {
+		return nil, err
+	}
+
+	return result.Results, nil
+}
+
+// GetStatus returns the delivery status of a message.
+func (c *Client) GetStatus(ctx context.Context, id string) (*Message, error) {
+
+	target, err := url.JoinPath(c.target, "messages", id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Message{}
+	if err := c.do(ctx, http.MethodGet, target, nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Cancel withdraws a message that has not been sent yet.
+func (c *Client) Cancel(ctx context.Context, id string) (*Message, error) {
+
+	target, err := url.JoinPath(c.target, "messages", id, "cancel")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Message{}
+	if err := c.do(ctx, http.MethodPost, target, nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Reschedule moves the delivery of a message that has not been sent yet.
+func (c *Client) Reschedule(ctx context.Context, id string, sendAt time.Time) (*Message, error) {
+
+	target, err := url.JoinPath(c.target, "messages", id, "reschedule")
 	if err != nil {
-		return fmt.Errorf("failed to marshal options: %w", err)
+		return nil, err
+	}
+
+	result := &Message{}
+	if err := c.do(ctx, http.MethodPost, target, &RescheduleOptions{SendAt: sendAt}, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// do sends options as the JSON body of a request and decodes the response
+// into result.
+func (c *Client) do(ctx context.Context, method string, target string, options any, result any) error {
+
+	var body io.Reader
+
+	if options != nil {
+		msg, err := json.Marshal(options)
+		if err != nil {
+			return fmt.Errorf("failed to marshal options: %w", err)
+		}
+		body = bytes.NewReader(msg)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.target, bytes.NewReader(msg))
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -55,13 +260,17 @@ func (c *Client) Send(ctx context.Context, options *MailTemplateOptions[any]) er
 	req.Header.Set("Pragma", "no-cache")
 	req.Header.Set("Expires", "0")
 
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
 	res, err := c.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
 		bodyBytes, err := io.ReadAll(res.Body)
 		if err != nil {
 			return fmt.Errorf("unexpected status code: %d", res.StatusCode)
@@ -70,5 +279,9 @@ func (c *Client) Send(ctx context.Context, options *MailTemplateOptions[any]) er
 		return fmt.Errorf("unexpected status code: %d,%s", res.StatusCode, body)
 	}
 
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
 	return nil
 }
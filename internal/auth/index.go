@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownKey = errors.New("unknown api key")
+
+// ParseKeys parses a comma separated list of name:key pairs, such as
+// "billing:secret1,shop:secret2", into a map of keys to names.
+func ParseKeys(value string) (map[string]string, error) {
+
+	keys := make(map[string]string)
+
+	for i, entry := range strings.Split(value, ",") {
+
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// The entries are not quoted in errors, as they hold the keys.
+		name, key, found := strings.Cut(entry, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !found || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid api key entry %d, expected name:key", i+1)
+		}
+
+		if _, exists := keys[key]; exists {
+			return nil, fmt.Errorf("duplicate api key of %s", name)
+		}
+
+		keys[key] = name
+	}
+
+	return keys, nil
+}
+
+// Keys holds the API keys the listeners accept. Only the SHA-256 sums of the
+// keys are kept, so that looking them up does not leak them through timing.
+type Keys struct {
+	clients map[[sha256.Size]byte]string
+	admins  map[[sha256.Size]byte]string
+}
+
+// New returns the keys of clients and admins, maps of keys to names. Admin
+// keys grant access to the admin APIs and may send mail as well.
+func New(clients map[string]string, admins map[string]string) *Keys {
+
+	k := &Keys{
+		clients: make(map[[sha256.Size]byte]string, len(clients)),
+		admins:  make(map[[sha256.Size]byte]string, len(admins)),
+	}
+
+	for key, name := range clients {
+		k.clients[sha256.Sum256([]byte(key))] = name
+	}
+
+	for key, name := range admins {
+		k.admins[sha256.Sum256([]byte(key))] = name
+	}
+
+	return k
+}
+
+// Required reports whether client API keys are configured, in which case
+// callers without a key or client certificate are rejected.
+func (k *Keys) Required() bool {
+	return len(k.clients) > 0
+}
+
+// Address returns the identity of a caller known only by its address.
+func Address(address string) string {
+	return "addr:" + address
+}
+
+// Anonymous reports whether client is known only by its address. Callers
+// behind the same NAT or proxy share that identity, so it owns no messages.
+func Anonymous(client string) bool {
+	return strings.HasPrefix(client, "addr:")
+}
+
+// Client returns the identity of the caller with key, prefixed with "key:"
+// for clients and "admin:" for admins, and whether the key is an admin key.
+// ErrUnknownKey is returned when the key is not configured.
+func (k *Keys) Client(key string) (string, bool, error) {
+
+	sum := sha256.Sum256([]byte(key))
+
+	if name, exists := k.admins[sum]; exists {
+		return "admin:" + name, true, nil
+	}
+
+	if name, exists := k.clients[sum]; exists {
+		return "key:" + name, false, nil
+	}
+
+	return "", false, ErrUnknownKey
+}
@@ -0,0 +1,339 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedHeaders are the headers covered by the signatures when the message
+// has them. List-Unsubscribe and List-Unsubscribe-Post must be signed for
+// one-click unsubscribe.
+var signedHeaders = []string{
+	"From",
+	"To",
+	"Cc",
+	"Reply-To",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"MIME-Version",
+	"Content-Type",
+	"List-Unsubscribe",
+	"List-Unsubscribe-Post",
+}
+
+// Key signs the mail of Domain. Signer is an *rsa.PrivateKey or an
+// ed25519.PrivateKey.
+type Key struct {
+	Domain   string
+	Selector string
+	Signer   crypto.Signer
+}
+
+// LoadKey reads a PEM encoded RSA or Ed25519 private key, in PKCS #1 or
+// PKCS #8 form.
+func LoadKey(domain string, selector string, path string) (*Key, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var signer crypto.Signer
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		signer, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var key any
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			switch key := key.(type) {
+			case *rsa.PrivateKey:
+				signer = key
+			case ed25519.PrivateKey:
+				signer = key
+			default:
+				err = fmt.Errorf("unsupported key type %T", key)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", path, err)
+	}
+
+	return &Key{
+		Domain:   strings.ToLower(domain),
+		Selector: selector,
+		Signer:   signer,
+	}, nil
+}
+
+// Signer adds DKIM-Signature headers to messages, using relaxed/relaxed
+// canonicalization.
+type Signer struct {
+	keys map[string][]*Key
+}
+
+// New returns a signer for keys. A domain with several keys gets one
+// signature per key, which allows rotating keys or signing with RSA and
+// Ed25519 side by side.
+func New(keys []*Key) *Signer {
+
+	signer := &Signer{
+		keys: make(map[string][]*Key),
+	}
+
+	for _, key := range keys {
+		signer.keys[key.Domain] = append(signer.keys[key.Domain], key)
+	}
+
+	return signer
+}
+
+// Sign signs msg with the keys of the domain of its From address. Messages
+// of domains without keys are returned unchanged.
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+
+	head, body := splitMessage(msg)
+	fields := parseHeader(head)
+
+	from, exists := fields["from"]
+	if !exists {
+		return nil, errors.New("dkim: message has no From header")
+	}
+
+	address, err := mail.ParseAddress(headerValue(from))
+	if err != nil {
+		return nil, fmt.Errorf("dkim: invalid From header: %v", err)
+	}
+
+	domain := strings.ToLower(address.Address[strings.LastIndex(address.Address, "@")+1:])
+
+	keys := s.keys[domain]
+	if len(keys) == 0 {
+		return msg, nil
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	names := []string{}
+	var signedHead bytes.Buffer
+
+	for _, name := range signedHeaders {
+		field, exists := fields[strings.ToLower(name)]
+		if !exists {
+			continue
+		}
+		names = append(names, name)
+		signedHead.WriteString(relaxedHeader(field))
+		signedHead.WriteString("\r\n")
+	}
+
+	var signatures bytes.Buffer
+
+	for _, key := range keys {
+
+		signature, err := sign(key, signedHead.Bytes(), names, bodyHash[:])
+		if err != nil {
+			return nil, err
+		}
+
+		signatures.WriteString(signature)
+	}
+
+	return append(signatures.Bytes(), msg...), nil
+}
+
+// sign returns the DKIM-Signature header of one key, folded and ending with
+// a newline.
+func sign(key *Key, signedHead []byte, names []string, bodyHash []byte) (string, error) {
+
+	var algorithm string
+
+	switch key.Signer.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return "", fmt.Errorf("dkim: unsupported key type %T", key.Signer)
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=relaxed/relaxed",
+		"d=" + key.Domain,
+		"s=" + key.Selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(names, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash),
+		"b=",
+	}
+
+	field := "DKIM-Signature: " + strings.Join(tags, "; ")
+
+	data := append(bytes.Clone(signedHead), relaxedHeader(field)...)
+	digest := sha256.Sum256(data)
+
+	var signature []byte
+	var err error
+
+	if _, isRSA := key.Signer.(*rsa.PrivateKey); isRSA {
+		signature, err = key.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	} else {
+		// RFC 8463 signs the SHA-256 digest with pure Ed25519.
+		signature, err = key.Signer.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return "", fmt.Errorf("dkim: %v", err)
+	}
+
+	return fold(field, base64.StdEncoding.EncodeToString(signature)) + "\n", nil
+}
+
+// splitMessage splits msg at the first empty line.
+func splitMessage(msg []byte) ([]byte, []byte) {
+
+	for i := 0; i < len(msg); i++ {
+		if msg[i] != '\n' {
+			continue
+		}
+		rest := msg[i+1:]
+		if bytes.HasPrefix(rest, []byte("\n")) {
+			return msg[:i+1], rest[1:]
+		}
+		if bytes.HasPrefix(rest, []byte("\r\n")) {
+			return msg[:i+1], rest[2:]
+		}
+	}
+
+	return msg, nil
+}
+
+// parseHeader returns the raw header fields by lowercase name. The last
+// occurrence of a repeated field wins, as it is the one verifiers pick
+// first.
+func parseHeader(head []byte) map[string]string {
+
+	fields := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+
+	var current string
+
+	flush := func() {
+		if name, _, found := strings.Cut(current, ":"); found {
+			fields[strings.ToLower(strings.TrimSpace(name))] = current
+		}
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			current += "\r\n" + line
+			continue
+		}
+		flush()
+		current = line
+	}
+	flush()
+
+	return fields
+}
+
+func headerValue(field string) string {
+	_, value, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(strings.ReplaceAll(value, "\r\n", ""))
+}
+
+// relaxedHeader canonicalizes a header field as described in RFC 6376
+// section 3.4.2, without the trailing CRLF.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.Join(strings.FieldsFunc(value, isWSP), " ")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// relaxedBody canonicalizes a body as described in RFC 6376 section 3.4.4.
+func relaxedBody(body []byte) []byte {
+
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		lines[i] = strings.Join(strings.FieldsFunc(line, isWSP), " ")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			lines[i] = " " + lines[i]
+		}
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// fold breaks a long header field into continuation lines. Lines are only
+// broken at the spaces between tags and inside the signature, as folding
+// anywhere else would change the relaxed form of the field.
+func fold(field string, signature string) string {
+
+	const width = 76
+
+	var folded strings.Builder
+	line := 0
+
+	for i, part := range strings.SplitAfter(field, " ") {
+		if i > 0 && line+len(part) > width {
+			folded.WriteString("\n\t")
+			line = 1
+		}
+		folded.WriteString(part)
+		line += len(part)
+	}
+
+	for len(signature) > 0 {
+
+		if line >= width {
+			folded.WriteString("\n\t")
+			line = 1
+		}
+
+		n := min(len(signature), width-line)
+		folded.WriteString(signature[:n])
+		signature = signature[n:]
+		line += n
+	}
+
+	return folded.String()
+}
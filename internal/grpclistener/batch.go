@@ -0,0 +1,61 @@
+package grpclistener
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxBatchSize = 1000
+
+// SendBatch reads messages from the stream until the client closes it, then
+// sends each of them and returns one result per message, in stream order.
+func (app *App) SendBatch(stream grpc.ClientStreamingServer[grpcstruct.MailTemplateRequest, grpcstruct.BatchResponse]) error {
+
+	var requests []*grpcstruct.MailTemplateRequest
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(requests) == maxBatchSize {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("batch exceeds %d messages", maxBatchSize))
+		}
+
+		requests = append(requests, req)
+	}
+
+	res := &grpcstruct.BatchResponse{
+		Results: make([]*grpcstruct.BatchItemResult, 0, len(requests)),
+	}
+
+	for i, req := range requests {
+
+		item := &grpcstruct.BatchItemResult{
+			Index: int32(i),
+		}
+
+		response, err := app.Send(stream.Context(), req)
+		if err != nil {
+			item.Error = err.Error()
+		} else {
+			item.Ok = response.Ok
+			item.Response = response
+		}
+
+		res.Results = append(res.Results, item)
+	}
+
+	return stream.SendAndClose(res)
+}
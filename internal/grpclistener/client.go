@@ -0,0 +1,149 @@
+package grpclistener
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/quota"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Keys of the caller identity in the request context.
+type (
+	clientKey struct{}
+	adminKey  struct{}
+)
+
+// adminMethods are the RPCs that require an admin API key.
+var adminMethods = map[string]struct{}{
+	grpcstruct.MailTemplate_ListDeadLetters_FullMethodName:   {},
+	grpcstruct.MailTemplate_GetDeadLetter_FullMethodName:     {},
+	grpcstruct.MailTemplate_RequeueDeadLetter_FullMethodName: {},
+	grpcstruct.MailTemplate_ListSuppressions_FullMethodName:  {},
+	grpcstruct.MailTemplate_AddSuppression_FullMethodName:    {},
+	grpcstruct.MailTemplate_RemoveSuppression_FullMethodName: {},
+	grpcstruct.MailTemplate_GetPGPKey_FullMethodName:         {},
+	grpcstruct.MailTemplate_PutPGPKey_FullMethodName:         {},
+	grpcstruct.MailTemplate_DeletePGPKey_FullMethodName:      {},
+	grpcstruct.MailTemplate_ListOutbox_FullMethodName:        {},
+}
+
+// authenticatedStream carries the caller identity in the context of a
+// stream.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authenticatedStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (app *App) authenticateUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+
+	ctx, err := app.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (app *App) authenticateStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, err := app.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate identifies the caller for the quota, by the subject of its
+// TLS client certificate, its API key or, failing both, its address.
+// Requests with an API key that is not configured, without any key when
+// keys are configured, and calls of admin methods without an admin API key
+// are rejected.
+func (app *App) authenticate(ctx context.Context, method string) (context.Context, error) {
+
+	client := ""
+	admin := false
+
+	p, hasPeer := peer.FromContext(ctx)
+
+	if hasPeer {
+		client = auth.Address(p.Addr.String())
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			client = auth.Address(host)
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get("x-api-key"); len(keys) > 0 && keys[0] != "" {
+
+			keyClient, keyAdmin, err := app.keys.Client(keys[0])
+			if err != nil {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+
+			client, admin = keyClient, keyAdmin
+		}
+	}
+
+	if hasPeer {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			client = "subject:" + info.State.PeerCertificates[0].Subject.String()
+		}
+	}
+
+	if app.keys.Required() && auth.Anonymous(client) {
+		return nil, status.Error(codes.Unauthenticated, "api key required")
+	}
+
+	if _, required := adminMethods[method]; required && !admin {
+		return nil, status.Error(codes.PermissionDenied, "admin api key required")
+	}
+
+	ctx = context.WithValue(ctx, clientKey{}, client)
+	ctx = context.WithValue(ctx, adminKey{}, admin)
+
+	return ctx, nil
+}
+
+// clientID returns the caller identity set by authenticate.
+func clientID(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// isAdmin reports whether authenticate found an admin API key.
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// quotaError converts an exceeded quota into RESOURCE_EXHAUSTED and sets the
+// retry-after header to the seconds until the quota resets.
+func quotaError(ctx context.Context, err error) error {
+
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return err
+	}
+
+	retryAfter := strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds())))
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+
+	return status.Error(codes.ResourceExhausted, err.Error())
+}
@@ -0,0 +1,103 @@
+package grpclistener
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchBuffer is the number of events a watcher may lag behind before its
+// stream is closed.
+const watchBuffer = 256
+
+// WatchEvents streams the status changes of messages matching the request
+// until the client goes away or the server stops. Clients only see the
+// events of their own messages, admins those of every message, and callers
+// known only by their address none.
+func (app *App) WatchEvents(req *grpcstruct.WatchEventsRequest, stream grpc.ServerStreamingServer[grpcstruct.Event]) error {
+
+	client, admin := clientID(stream.Context()), isAdmin(stream.Context())
+
+	if !admin && auth.Anonymous(client) {
+		return status.Error(codes.Unauthenticated, "api key required")
+	}
+
+	events := make(chan *queue.Event, watchBuffer)
+	overflow := make(chan struct{})
+	var once sync.Once
+
+	unsubscribe := app.queue.Subscribe(func(event *queue.Event) {
+
+		if !admin && event.Client != client {
+			return
+		}
+
+		if !matchEvent(req, event) {
+			return
+		}
+
+		select {
+		case events <- event:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(toEvent(event)); err != nil {
+				return err
+			}
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "event stream fell behind")
+		case <-stream.Context().Done():
+			return nil
+		case <-app.ctx.Done():
+			return status.Error(codes.Unavailable, "server stopping")
+		}
+	}
+}
+
+func matchEvent(req *grpcstruct.WatchEventsRequest, event *queue.Event) bool {
+
+	if req.TemplateGroup != "" && req.TemplateGroup != event.TemplateGroup {
+		return false
+	}
+
+	if req.MessageId != "" && req.MessageId != event.MessageID {
+		return false
+	}
+
+	if req.Recipient != "" {
+		for _, to := range event.To {
+			if strings.EqualFold(to, req.Recipient) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func toEvent(event *queue.Event) *grpcstruct.Event {
+	return &grpcstruct.Event{
+		MessageId:     event.MessageID,
+		Status:        string(event.Status),
+		TemplateGroup: event.TemplateGroup,
+		To:            event.To,
+		Code:          int32(event.Code),
+		Error:         event.Error,
+		At:            timestamppb.New(event.At),
+	}
+}
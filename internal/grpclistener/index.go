@@ -4,48 +4,71 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"net"
 
 	"github.com/lucap9056/mail-template-sender/grpcstruct"
-	"github.com/lucap9056/mail-template-sender/internal/smtp"
-	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/mailer"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
+// Mailer sends the requests of the listener, as *mailer.Mailer does.
+type Mailer interface {
+	Send(req *mailer.Request) ([]*mailer.Result, error)
+}
+
 type App struct {
 	grpcstruct.UnimplementedMailTemplateServer
-	server         *grpc.Server
-	client         *smtp.SMTP
-	templateGroups *template.TemplateGroups
-	ctx            context.Context
-	cancel         context.CancelFunc
+	server       *grpc.Server
+	mailer       Mailer
+	queue        *queue.Queue
+	suppressions *suppression.List
+	pgpKeys      *pgp.KeyStore
+	outbox       *transport.Memory
+	keys         *auth.Keys
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
-func New(client *smtp.SMTP, templateGroups *template.TemplateGroups, tlsConfig *tls.Config) (*App, error) {
-
-	var server *grpc.Server
+// New creates the gRPC listener. pgpKeys is nil when OpenPGP is not
+// configured, and outbox when no backend uses the memory transport.
+func New(mailer Mailer, queue *queue.Queue, suppressions *suppression.List, pgpKeys *pgp.KeyStore, outbox *transport.Memory, keys *auth.Keys, tlsConfig *tls.Config) (*App, error) {
 
-	if tlsConfig != nil {
-		creds := credentials.NewTLS(tlsConfig)
-		server = grpc.NewServer(grpc.Creds(creds))
+	ctx, cancel := context.WithCancel(context.Background())
 
-	} else {
-		server = grpc.NewServer()
+	app := &App{
+		mailer:       mailer,
+		queue:        queue,
+		suppressions: suppressions,
+		pgpKeys:      pgpKeys,
+		outbox:       outbox,
+		keys:         keys,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(app.authenticateUnary),
+		grpc.StreamInterceptor(app.authenticateStream),
+	}
 
-	app := &App{
-		server:         server,
-		client:         client,
-		templateGroups: templateGroups,
-		ctx:            ctx,
-		cancel:         cancel,
+	if tlsConfig != nil {
+		creds := credentials.NewTLS(tlsConfig)
+		options = append(options, grpc.Creds(creds))
 	}
 
-	grpcstruct.RegisterMailTemplateServer(server, app)
+	app.server = grpc.NewServer(options...)
+
+	grpcstruct.RegisterMailTemplateServer(app.server, app)
 
 	return app, nil
 }
@@ -56,27 +79,103 @@ func (app *App) Send(ctx context.Context, req *grpcstruct.MailTemplateRequest) (
 		Ok: false,
 	}
 
-	var data any
-
-	err := json.Unmarshal(req.DataJson, &data)
+	data, err := unmarshalData(req.DataJson)
 	if err != nil {
 		return res, err
 	}
 
-	msg, err := app.templateGroups.ToText(req.TemplateGroup, req.TemplateNames, app.client.Username(), req.To, data)
+	request := &mailer.Request{
+		TemplateGroup:   req.TemplateGroup,
+		TemplateVersion: req.TemplateVersion,
+		TemplateNames:   req.TemplateNames,
+		From:            req.From,
+		To:              req.To,
+		Cc:              req.Cc,
+		Bcc:             req.Bcc,
+		ReplyTo:         req.ReplyTo,
+		Headers:         req.Headers,
+		Data:            data,
+		IdempotencyKey:  req.IdempotencyKey,
+		Tag:             req.Tag,
+		Client:          clientID(ctx),
+	}
+
+	if req.SendAt != nil {
+		request.SendAt = req.SendAt.AsTime()
+		if err := queue.CheckSchedule(request.SendAt); err != nil {
+			return res, queueError(err)
+		}
+	}
+
+	for _, recipient := range req.Recipients {
+
+		data, err := unmarshalData(recipient.DataJson)
+		if err != nil {
+			return res, err
+		}
+
+		request.Recipients = append(request.Recipients, mailer.Recipient{
+			Address: recipient.Address,
+			Data:    data,
+		})
+	}
+
+	results, err := app.mailer.Send(request)
+	if errors.Is(err, mailer.ErrInvalidAddress) {
+		return res, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if err != nil {
-		return res, err
+		return res, quotaError(ctx, err)
 	}
 
-	if err := app.client.Send(req.To, msg); err != nil {
-		return res, err
+	if len(req.Recipients) == 0 {
+		res.Ok = true
+		res.MessageId = results[0].MessageID
+		res.TemplateVersion = results[0].TemplateVersion
+		res.Variant = results[0].Variant
+		return res, nil
 	}
 
 	res.Ok = true
 
+	for _, result := range results {
+
+		recipientResult := &grpcstruct.RecipientResult{
+			Address:         result.To[0],
+			Ok:              result.Err == nil,
+			MessageId:       result.MessageID,
+			TemplateVersion: result.TemplateVersion,
+			Variant:         result.Variant,
+		}
+
+		if result.Err != nil {
+			res.Ok = false
+			recipientResult.Error = result.Err.Error()
+		}
+
+		res.Results = append(res.Results, recipientResult)
+	}
+
 	return res, nil
 }
 
+// unmarshalData decodes request data. Empty data decodes to nil.
+func unmarshalData(dataJson []byte) (any, error) {
+
+	if len(dataJson) == 0 {
+		return nil, nil
+	}
+
+	var data any
+
+	err := json.Unmarshal(dataJson, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
 func (a *App) Run(addr string) error {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -0,0 +1,163 @@
+package grpclistener
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (app *App) ListDeadLetters(ctx context.Context, req *grpcstruct.ListDeadLettersRequest) (*grpcstruct.ListDeadLettersResponse, error) {
+
+	messages, err := app.queue.DeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &grpcstruct.ListDeadLettersResponse{}
+
+	for _, msg := range messages {
+		res.Messages = append(res.Messages, toMessage(msg, false))
+	}
+
+	return res, nil
+}
+
+func (app *App) GetDeadLetter(ctx context.Context, req *grpcstruct.MessageRequest) (*grpcstruct.Message, error) {
+
+	msg, err := app.queue.GetDeadLetter(req.Id)
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return toMessage(msg, true), nil
+}
+
+func (app *App) RequeueDeadLetter(ctx context.Context, req *grpcstruct.MessageRequest) (*grpcstruct.Message, error) {
+
+	msg, err := app.queue.Requeue(req.Id)
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return toMessage(msg, false), nil
+}
+
+func (app *App) GetStatus(ctx context.Context, req *grpcstruct.MessageRequest) (*grpcstruct.Message, error) {
+
+	msg, err := ownMessage(ctx, app.queue, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(msg, false), nil
+}
+
+func (app *App) Cancel(ctx context.Context, req *grpcstruct.MessageRequest) (*grpcstruct.Message, error) {
+
+	if _, err := ownMessage(ctx, app.queue, req.Id); err != nil {
+		return nil, err
+	}
+
+	msg, err := app.queue.Cancel(req.Id)
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return toMessage(msg, false), nil
+}
+
+func (app *App) Reschedule(ctx context.Context, req *grpcstruct.RescheduleRequest) (*grpcstruct.Message, error) {
+
+	if req.SendAt == nil {
+		return nil, status.Error(codes.InvalidArgument, "send_at is required")
+	}
+
+	if err := queue.CheckSchedule(req.SendAt.AsTime()); err != nil {
+		return nil, queueError(err)
+	}
+
+	if _, err := ownMessage(ctx, app.queue, req.Id); err != nil {
+		return nil, err
+	}
+
+	msg, err := app.queue.Reschedule(req.Id, req.SendAt.AsTime())
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return toMessage(msg, false), nil
+}
+
+// ownMessage returns the message id when the caller sent it or is an admin.
+// Messages of other clients are reported as not found, and callers known
+// only by their address own no messages.
+func ownMessage(ctx context.Context, messages *queue.Queue, id string) (*queue.Message, error) {
+
+	if !isAdmin(ctx) && auth.Anonymous(clientID(ctx)) {
+		return nil, status.Error(codes.Unauthenticated, "api key required")
+	}
+
+	msg, err := messages.Get(id)
+	if err == nil && !isAdmin(ctx) && msg.Client != clientID(ctx) {
+		err = queue.ErrNotFound
+	}
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return msg, nil
+}
+
+func queueError(err error) error {
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, queue.ErrNotPending):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, queue.ErrSchedule):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+// toMessage converts a queued message. The composed message is only
+// included when withData is set.
+func toMessage(msg *queue.Message, withData bool) *grpcstruct.Message {
+
+	message := &grpcstruct.Message{
+		Id:              msg.ID,
+		Status:          string(msg.Status),
+		TemplateGroup:   msg.TemplateGroup,
+		TemplateVersion: msg.TemplateVersion,
+		Variant:         msg.Variant,
+		Backend:         msg.Backend,
+		From:            msg.From,
+		To:              msg.To,
+		Error:           msg.Error,
+		CreatedAt:       timestamppb.New(msg.CreatedAt),
+		UpdatedAt:       timestamppb.New(msg.UpdatedAt),
+		DueAt:           timestamppb.New(msg.DueAt),
+	}
+
+	for _, attempt := range msg.Attempts {
+		message.Attempts = append(message.Attempts, &grpcstruct.Attempt{
+			At:      timestamppb.New(attempt.At),
+			Backend: attempt.Backend,
+			Code:    int32(attempt.Code),
+			Error:   attempt.Error,
+		})
+	}
+
+	if withData {
+		message.Data = msg.Data
+	}
+
+	return message
+}
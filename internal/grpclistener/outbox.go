@@ -0,0 +1,33 @@
+package grpclistener
+
+import (
+	"context"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ListOutbox returns the messages captured by the memory transport, oldest
+// first.
+func (app *App) ListOutbox(ctx context.Context, req *grpcstruct.ListOutboxRequest) (*grpcstruct.ListOutboxResponse, error) {
+
+	if app.outbox == nil {
+		return nil, status.Error(codes.FailedPrecondition, "memory transport is not configured")
+	}
+
+	res := &grpcstruct.ListOutboxResponse{}
+
+	for _, msg := range app.outbox.Messages() {
+		res.Messages = append(res.Messages, &grpcstruct.CapturedMessage{
+			From: msg.From,
+			To:   msg.To,
+			Data: msg.Data,
+			At:   timestamppb.New(msg.At),
+		})
+	}
+
+	return res, nil
+}
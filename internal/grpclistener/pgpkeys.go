@@ -0,0 +1,75 @@
+package grpclistener
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errPGPNotConfigured = status.Error(codes.FailedPrecondition, "openpgp is not configured")
+
+func (app *App) GetPGPKey(ctx context.Context, req *grpcstruct.PGPKeyRequest) (*grpcstruct.PGPKey, error) {
+
+	if app.pgpKeys == nil {
+		return nil, errPGPNotConfigured
+	}
+
+	armored, err := app.pgpKeys.Armored(req.Address)
+	if err != nil {
+		if errors.Is(err, pgp.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+
+	key, err := app.pgpKeys.Get(req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcstruct.PGPKey{
+		Address:     req.Address,
+		Fingerprint: pgp.Fingerprint(key),
+		ArmoredKey:  string(armored),
+	}, nil
+}
+
+// PutPGPKey registers or replaces the public key of an address.
+func (app *App) PutPGPKey(ctx context.Context, req *grpcstruct.PGPKey) (*grpcstruct.PGPKey, error) {
+
+	if app.pgpKeys == nil {
+		return nil, errPGPNotConfigured
+	}
+
+	fingerprint, err := app.pgpKeys.Put(req.Address, []byte(req.ArmoredKey))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &grpcstruct.PGPKey{
+		Address:     req.Address,
+		Fingerprint: fingerprint,
+		ArmoredKey:  req.ArmoredKey,
+	}, nil
+}
+
+func (app *App) DeletePGPKey(ctx context.Context, req *grpcstruct.PGPKeyRequest) (*grpcstruct.PGPKey, error) {
+
+	if app.pgpKeys == nil {
+		return nil, errPGPNotConfigured
+	}
+
+	if err := app.pgpKeys.Delete(req.Address); err != nil {
+		if errors.Is(err, pgp.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &grpcstruct.PGPKey{Address: req.Address}, nil
+}
@@ -0,0 +1,68 @@
+package grpclistener
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lucap9056/mail-template-sender/grpcstruct"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (app *App) ListSuppressions(ctx context.Context, req *grpcstruct.ListSuppressionsRequest) (*grpcstruct.ListSuppressionsResponse, error) {
+
+	entries, err := app.suppressions.Entries(req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &grpcstruct.ListSuppressionsResponse{}
+
+	for _, entry := range entries {
+		res.Suppressions = append(res.Suppressions, toSuppression(entry))
+	}
+
+	return res, nil
+}
+
+func (app *App) AddSuppression(ctx context.Context, req *grpcstruct.Suppression) (*grpcstruct.Suppression, error) {
+
+	entry := &suppression.Entry{
+		Address: req.Address,
+		Scope:   req.Scope,
+		Reason:  req.Reason,
+		Source:  suppression.SourceAdmin,
+	}
+
+	if err := app.suppressions.Add(entry); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toSuppression(entry), nil
+}
+
+func (app *App) RemoveSuppression(ctx context.Context, req *grpcstruct.RemoveSuppressionRequest) (*grpcstruct.Suppression, error) {
+
+	entry, err := app.suppressions.Remove(req.Address, req.Scope)
+	if err != nil {
+		if errors.Is(err, suppression.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+
+	return toSuppression(entry), nil
+}
+
+func toSuppression(entry *suppression.Entry) *grpcstruct.Suppression {
+	return &grpcstruct.Suppression{
+		Address:   entry.Address,
+		Scope:     entry.Scope,
+		Reason:    entry.Reason,
+		Source:    entry.Source,
+		CreatedAt: timestamppb.New(entry.CreatedAt),
+	}
+}
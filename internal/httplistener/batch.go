@@ -0,0 +1,60 @@
+package httplistener
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+)
+
+const maxBatchSize = 1000
+
+// Batch sends every message of the request body and responds with one result
+// per message, in request order. A failing message does not stop the others.
+func (app *App) Batch(c *gin.Context) {
+
+	body := &httpclient.BatchOptions[any]{}
+
+	if err := c.BindJSON(body); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println(err.Error())
+		return
+	}
+
+	if len(body.Messages) > maxBatchSize {
+		c.String(http.StatusBadRequest, fmt.Sprintf("batch exceeds %d messages", maxBatchSize))
+		return
+	}
+
+	response := &httpclient.BatchResponse{
+		Results: make([]httpclient.BatchResult, 0, len(body.Messages)),
+	}
+
+	client := clientID(c)
+
+	for i := range body.Messages {
+
+		item := httpclient.BatchResult{
+			Index: i,
+		}
+
+		result, err := app.send(&body.Messages[i], client)
+		if err != nil {
+			item.Error = err.Error()
+			log.Println("send error: ", err.Error())
+		} else {
+			item.Ok = true
+			item.Result = result
+
+			for _, recipientResult := range result.Results {
+				item.Ok = item.Ok && recipientResult.Ok
+			}
+		}
+
+		response.Results = append(response.Results, item)
+	}
+
+	c.JSON(http.StatusAccepted, response)
+}
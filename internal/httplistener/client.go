@@ -0,0 +1,83 @@
+package httplistener
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/quota"
+)
+
+// Keys of the caller identity in the gin context.
+const (
+	clientKey = "client"
+	adminKey  = "admin"
+)
+
+// authenticate identifies the caller for the quota, by the subject of its
+// TLS client certificate, its API key or, failing both, its address.
+// Requests with an API key that is not configured are rejected. Only admin
+// API keys mark the caller as an admin.
+func (app *App) authenticate(c *gin.Context) {
+
+	client := auth.Address(c.ClientIP())
+
+	if key := c.GetHeader("X-API-Key"); key != "" {
+
+		keyClient, admin, err := app.keys.Client(key)
+		if err != nil {
+			c.String(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+
+		client = keyClient
+		c.Set(adminKey, admin)
+	}
+
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		client = "subject:" + c.Request.TLS.PeerCertificates[0].Subject.String()
+	}
+
+	c.Set(clientKey, client)
+}
+
+// requireClient rejects the callers known only by their address when API
+// keys are configured.
+func (app *App) requireClient(c *gin.Context) {
+	if app.keys.Required() && auth.Anonymous(clientID(c)) {
+		c.String(http.StatusUnauthorized, "api key required")
+		c.Abort()
+	}
+}
+
+// requireAdmin rejects callers without an admin API key.
+func (app *App) requireAdmin(c *gin.Context) {
+	if !c.GetBool(adminKey) {
+		c.String(http.StatusForbidden, "admin api key required")
+		c.Abort()
+	}
+}
+
+// clientID returns the caller identity set by authenticate.
+func clientID(c *gin.Context) string {
+	return c.GetString(clientKey)
+}
+
+// quotaError responds with 429 and a Retry-After header when err is an
+// exceeded quota.
+func quotaError(c *gin.Context, err error) bool {
+
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+	c.String(http.StatusTooManyRequests, err.Error())
+
+	return true
+}
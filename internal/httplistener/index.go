@@ -6,38 +6,101 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/lucap9056/mail-template-sender/internal/smtp"
-
 	"github.com/gin-gonic/gin"
 	"github.com/lucap9056/mail-template-sender/httpclient"
-	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/mailer"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
 )
 
+// Mailer sends the requests of the listener, as *mailer.Mailer does.
+type Mailer interface {
+	Send(req *mailer.Request) ([]*mailer.Result, error)
+	Unsubscribe(token string) (*suppression.Entry, error)
+}
+
 type App struct {
-	client         *smtp.SMTP
-	templateGroups *template.TemplateGroups
-	router         *gin.Engine
-	ctx            context.Context
-	cancel         context.CancelFunc
+	mailer       Mailer
+	queue        *queue.Queue
+	suppressions *suppression.List
+	pgpKeys      *pgp.KeyStore
+	outbox       *transport.Memory
+	keys         *auth.Keys
+	router       *gin.Engine
+	unsubscribe  *gin.Engine
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
-func New(client *smtp.SMTP, templateGroups *template.TemplateGroups) *App {
+// New creates the HTTP listener. pgpKeys is nil when OpenPGP is not
+// configured, and outbox when no backend uses the memory transport. The
+// client address is taken from the X-Forwarded-For header only for requests
+// from trustedProxies.
+func New(mailer Mailer, queue *queue.Queue, suppressions *suppression.List, pgpKeys *pgp.KeyStore, outbox *transport.Memory, keys *auth.Keys, trustedProxies []string) (*App, error) {
 
 	router := gin.Default()
 
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+
+	unsubscribe := gin.Default()
+
+	if err := unsubscribe.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	app := &App{
-		client:         client,
-		templateGroups: templateGroups,
-		router:         router,
-		ctx:            ctx,
-		cancel:         cancel,
+		mailer:       mailer,
+		queue:        queue,
+		suppressions: suppressions,
+		pgpKeys:      pgpKeys,
+		outbox:       outbox,
+		keys:         keys,
+		router:       router,
+		unsubscribe:  unsubscribe,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 
-	router.POST("/", app.Handler)
+	router.Use(app.authenticate)
+
+	// Mailbox providers follow the unsubscribe links without an API key.
+	router.GET("/unsubscribe", app.UnsubscribePage)
+	router.POST("/unsubscribe", app.Unsubscribe)
+
+	// The unsubscribe links are also served alone, for when clients of the
+	// API must present a certificate that mailbox providers do not have.
+	unsubscribe.GET("/unsubscribe", app.UnsubscribePage)
+	unsubscribe.POST("/unsubscribe", app.Unsubscribe)
+
+	api := router.Group("", app.requireClient)
 
-	return app
+	api.POST("/", app.Handler)
+	api.POST("/batch", app.Batch)
+
+	api.GET("/messages/:id", app.GetStatus)
+	api.POST("/messages/:id/cancel", app.Cancel)
+	api.POST("/messages/:id/reschedule", app.Reschedule)
+
+	admin := api.Group("/admin", app.requireAdmin)
+	admin.GET("/dead-letters", app.ListDeadLetters)
+	admin.GET("/dead-letters/:id", app.GetDeadLetter)
+	admin.POST("/dead-letters/:id/requeue", app.RequeueDeadLetter)
+	admin.GET("/suppressions", app.ListSuppressions)
+	admin.POST("/suppressions", app.AddSuppression)
+	admin.DELETE("/suppressions/:address", app.RemoveSuppression)
+	admin.GET("/pgp-keys/:address", app.GetPGPKey)
+	admin.PUT("/pgp-keys/:address", app.PutPGPKey)
+	admin.DELETE("/pgp-keys/:address", app.DeletePGPKey)
+	admin.GET("/outbox", app.ListOutbox)
+
+	return app, nil
 }
 
 func (app *App) Handler(c *gin.Context) {
@@ -50,34 +113,103 @@ func (app *App) Handler(c *gin.Context) {
 		return
 	}
 
-	msg, err := app.templateGroups.ToText(
-		body.TemplateGroup,
-		body.TemplateNames,
-		app.client.Username(),
-		body.Targets,
-		body.Data,
-	)
-
+	result, err := app.send(body, clientID(c))
 	if err != nil {
-		c.String(http.StatusBadRequest, err.Error())
-		log.Println("to text error: ", err.Error())
+		log.Println("send error: ", err.Error())
+		if !quotaError(c, err) {
+			c.String(http.StatusBadRequest, err.Error())
+		}
 		return
 	}
 
-	if err := app.client.Send(body.Targets, msg); err != nil {
-		c.String(http.StatusBadRequest, err.Error())
-		log.Println("smtp send error: ", err.Error())
-		return
+	c.JSON(http.StatusAccepted, result)
+}
+
+func (app *App) send(body *httpclient.MailTemplateOptions[any], client string) (*httpclient.MailTemplateResult, error) {
+
+	request := &mailer.Request{
+		TemplateGroup:   body.TemplateGroup,
+		TemplateVersion: body.TemplateVersion,
+		TemplateNames:   body.TemplateNames,
+		From:            body.From,
+		To:              body.Targets,
+		Cc:              body.Cc,
+		Bcc:             body.Bcc,
+		ReplyTo:         body.ReplyTo,
+		Headers:         body.Headers,
+		Data:            body.Data,
+		IdempotencyKey:  body.IdempotencyKey,
+		Tag:             body.Tag,
+		Client:          client,
+	}
+
+	if body.SendAt != nil {
+		if err := queue.CheckSchedule(*body.SendAt); err != nil {
+			return nil, err
+		}
+		request.SendAt = *body.SendAt
+	}
+
+	for _, recipient := range body.Recipients {
+		request.Recipients = append(request.Recipients, mailer.Recipient{
+			Address: recipient.Address,
+			Data:    recipient.Data,
+		})
+	}
+
+	results, err := app.mailer.Send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body.Recipients) == 0 {
+		return &httpclient.MailTemplateResult{
+			MessageID:       results[0].MessageID,
+			TemplateVersion: results[0].TemplateVersion,
+			Variant:         results[0].Variant,
+		}, nil
+	}
+
+	response := &httpclient.MailTemplateResult{
+		Results: make([]httpclient.RecipientResult, 0, len(results)),
 	}
 
-	c.String(http.StatusOK, "")
+	for _, result := range results {
+
+		recipientResult := httpclient.RecipientResult{
+			Address:         result.To[0],
+			Ok:              result.Err == nil,
+			MessageID:       result.MessageID,
+			TemplateVersion: result.TemplateVersion,
+			Variant:         result.Variant,
+		}
+
+		if result.Err != nil {
+			recipientResult.Error = result.Err.Error()
+			log.Println("send error: ", result.Err.Error())
+		}
+
+		response.Results = append(response.Results, recipientResult)
+	}
+
+	return response, nil
 }
 
 func (app *App) Run(addr string, tlsConfig *tls.Config) error {
+	return app.serve(app.router, addr, tlsConfig)
+}
+
+// RunUnsubscribe serves only the unsubscribe endpoint on addr. tlsConfig
+// must not require client certificates.
+func (app *App) RunUnsubscribe(addr string, tlsConfig *tls.Config) error {
+	return app.serve(app.unsubscribe, addr, tlsConfig)
+}
+
+func (app *App) serve(handler http.Handler, addr string, tlsConfig *tls.Config) error {
 
 	server := &http.Server{
 		Addr:      addr,
-		Handler:   app.router,
+		Handler:   handler,
 		TLSConfig: tlsConfig,
 	}
 
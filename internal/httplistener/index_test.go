@@ -0,0 +1,285 @@
+package httplistener
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/mailer"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/relay"
+	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
+)
+
+// newTestApp returns a listener that sends through a memory transport, with
+// the client keys "shop" and "billing" and the admin key "ops".
+func newTestApp(t *testing.T) (*App, *transport.Memory) {
+
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+
+	group := filepath.Join(dir, "templates", "welcome")
+	if err := os.MkdirAll(group, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	page := `<html><head><title>Welcome {{.name}}</title></head><body>Hello {{.name}}</body></html>`
+	if err := os.WriteFile(filepath.Join(group, "welcome.html"), []byte(page), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := template.New(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := queue.New(filepath.Join(dir, "queue.db"), &queue.RetryPolicy{InitialInterval: time.Minute}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := transport.NewMemory(0)
+
+	backends, err := relay.New([]*relay.Backend{{Name: "default", Transport: outbox}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := mailer.New(backends, templates, messages, &mailer.Config{
+		From: &mail.Address{Address: "noreply@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages.Start(1, service.Deliver)
+	t.Cleanup(func() { messages.Close() })
+
+	keys := auth.New(
+		map[string]string{"shop-key": "shop", "billing-key": "billing"},
+		map[string]string{"ops-key": "ops"},
+	)
+
+	app, err := New(service, messages, nil, nil, outbox, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, outbox
+}
+
+func request(app *App, method string, target string, key string, body any) *httptest.ResponseRecorder {
+
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, target, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+
+	res := httptest.NewRecorder()
+	app.router.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestSendThroughMemoryTransport(t *testing.T) {
+
+	app, outbox := newTestApp(t)
+
+	res := request(app, http.MethodPost, "/", "shop-key", &httpclient.MailTemplateOptions[any]{
+		TemplateGroup: "welcome",
+		TemplateNames: []string{"welcome.html"},
+		Targets:       []string{"alice@example.com"},
+		Data:          map[string]string{"name": "Alice"},
+	})
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("send: got status %d: %s", res.Code, res.Body.String())
+	}
+
+	result := &httpclient.MailTemplateResult{}
+	if err := json.Unmarshal(res.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if result.MessageID == "" {
+		t.Fatalf("send: no message id in %s", res.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(outbox.Messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the message was not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sent := outbox.Messages()[0]
+	if len(sent.To) != 1 || sent.To[0] != "alice@example.com" {
+		t.Errorf("sent to %v, expected alice@example.com", sent.To)
+	}
+	if !strings.Contains(string(sent.Data), "Hello Alice") {
+		t.Errorf("sent message does not hold the rendered template:\n%s", sent.Data)
+	}
+
+	res = request(app, http.MethodGet, "/admin/outbox", "ops-key", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("outbox: got status %d: %s", res.Code, res.Body.String())
+	}
+
+	captured := []*httpclient.CapturedMessage{}
+	if err := json.Unmarshal(res.Body.Bytes(), &captured); err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 || !strings.Contains(captured[0].Data, "Hello Alice") {
+		t.Errorf("outbox: unexpected messages %s", res.Body.String())
+	}
+
+	if res := request(app, http.MethodGet, "/messages/"+result.MessageID, "shop-key", nil); res.Code != http.StatusOK {
+		t.Errorf("status of own message: got status %d", res.Code)
+	}
+	if res := request(app, http.MethodGet, "/messages/"+result.MessageID, "billing-key", nil); res.Code != http.StatusNotFound {
+		t.Errorf("status of another client's message: got status %d, expected %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		target string
+		key    string
+		status int
+	}{
+		{"unknown key", "/messages/missing", "wrong-key", http.StatusUnauthorized},
+		{"client key on admin route", "/admin/outbox", "shop-key", http.StatusForbidden},
+		{"no key", "/messages/missing", "", http.StatusUnauthorized},
+		{"no key on admin route", "/admin/outbox", "", http.StatusUnauthorized},
+		{"admin key on admin route", "/admin/outbox", "ops-key", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if res := request(app, http.MethodGet, test.target, test.key, nil); res.Code != test.status {
+				t.Errorf("got status %d, expected %d", res.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestSendRejectsInvalidAddresses(t *testing.T) {
+
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		name    string
+		options *httpclient.MailTemplateOptions[any]
+	}{
+		{"line break in bcc", &httpclient.MailTemplateOptions[any]{
+			Targets: []string{"alice@example.com"},
+			Bcc:     []string{"bob@x>\r\nRCPT TO:<evil@attacker.example"},
+		}},
+		{"display name in to", &httpclient.MailTemplateOptions[any]{
+			Targets: []string{"Alice <alice@example.com>"},
+		}},
+		{"no domain in recipients", &httpclient.MailTemplateOptions[any]{
+			Recipients: []httpclient.Recipient[any]{{Address: "alice"}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.options.TemplateGroup = "welcome"
+			test.options.TemplateNames = []string{"welcome.html"}
+
+			res := request(app, http.MethodPost, "/", "shop-key", test.options)
+			if res.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, expected %d: %s", res.Code, http.StatusBadRequest, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnonymousCallersOwnNoMessages(t *testing.T) {
+
+	app, _ := newTestApp(t)
+
+	// Without client keys, callers without a key are known by their address.
+	app.keys = auth.New(nil, map[string]string{"ops-key": "ops"})
+
+	res := request(app, http.MethodPost, "/", "", &httpclient.MailTemplateOptions[any]{
+		TemplateGroup: "welcome",
+		TemplateNames: []string{"welcome.html"},
+		Targets:       []string{"alice@example.com"},
+	})
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("send: got status %d: %s", res.Code, res.Body.String())
+	}
+
+	result := &httpclient.MailTemplateResult{}
+	if err := json.Unmarshal(res.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := request(app, http.MethodPost, "/messages/"+result.MessageID+"/cancel", "", nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("cancel without a key: got status %d, expected %d", res.Code, http.StatusUnauthorized)
+	}
+	if res := request(app, http.MethodGet, "/messages/"+result.MessageID, "ops-key", nil); res.Code != http.StatusOK {
+		t.Errorf("status with an admin key: got status %d", res.Code)
+	}
+}
+
+func TestSendRejectsSendTimesOutOfRange(t *testing.T) {
+
+	app, _ := newTestApp(t)
+
+	for _, sendAt := range []time.Time{
+		time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		res := request(app, http.MethodPost, "/", "shop-key", &httpclient.MailTemplateOptions[any]{
+			TemplateGroup: "welcome",
+			TemplateNames: []string{"welcome.html"},
+			Targets:       []string{"alice@example.com"},
+			SendAt:        &sendAt,
+		})
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("send at %s: got status %d, expected %d", sendAt, res.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUnsubscribeListenerServesOnlyUnsubscribe(t *testing.T) {
+
+	app, _ := newTestApp(t)
+
+	res := httptest.NewRecorder()
+	app.unsubscribe.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/admin/outbox", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("admin route: got status %d, expected %d", res.Code, http.StatusNotFound)
+	}
+
+	res = httptest.NewRecorder()
+	app.unsubscribe.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/unsubscribe?token=invalid", nil))
+	if res.Code == http.StatusNotFound {
+		t.Errorf("unsubscribe: the route is not served")
+	}
+}
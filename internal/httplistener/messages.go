@@ -0,0 +1,178 @@
+package httplistener
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+	"github.com/lucap9056/mail-template-sender/internal/auth"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+)
+
+func (app *App) ListDeadLetters(c *gin.Context) {
+
+	messages, err := app.queue.DeadLetters()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("dead letters error: ", err.Error())
+		return
+	}
+
+	response := make([]*httpclient.Message, 0, len(messages))
+	for _, msg := range messages {
+		response = append(response, toMessage(msg, false))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (app *App) GetDeadLetter(c *gin.Context) {
+
+	msg, err := app.queue.GetDeadLetter(c.Param("id"))
+	if err != nil {
+		queueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toMessage(msg, true))
+}
+
+func (app *App) RequeueDeadLetter(c *gin.Context) {
+
+	msg, err := app.queue.Requeue(c.Param("id"))
+	if err != nil {
+		queueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toMessage(msg, false))
+}
+
+func (app *App) GetStatus(c *gin.Context) {
+
+	msg, ok := app.ownMessage(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, toMessage(msg, false))
+}
+
+func (app *App) Cancel(c *gin.Context) {
+
+	msg, ok := app.ownMessage(c)
+	if !ok {
+		return
+	}
+
+	msg, err := app.queue.Cancel(msg.ID)
+	if err != nil {
+		queueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toMessage(msg, false))
+}
+
+func (app *App) Reschedule(c *gin.Context) {
+
+	body := &httpclient.RescheduleOptions{}
+
+	if err := c.BindJSON(body); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println(err.Error())
+		return
+	}
+
+	if err := queue.CheckSchedule(body.SendAt); err != nil {
+		queueError(c, err)
+		return
+	}
+
+	msg, ok := app.ownMessage(c)
+	if !ok {
+		return
+	}
+
+	msg, err := app.queue.Reschedule(msg.ID, body.SendAt)
+	if err != nil {
+		queueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toMessage(msg, false))
+}
+
+// ownMessage returns the message of the id parameter when the caller sent
+// it or is an admin. Messages of other clients are reported as not found,
+// and callers known only by their address own no messages.
+func (app *App) ownMessage(c *gin.Context) (*queue.Message, bool) {
+
+	if !c.GetBool(adminKey) && auth.Anonymous(clientID(c)) {
+		c.String(http.StatusUnauthorized, "api key required")
+		return nil, false
+	}
+
+	msg, err := app.queue.Get(c.Param("id"))
+	if err == nil && !c.GetBool(adminKey) && msg.Client != clientID(c) {
+		err = queue.ErrNotFound
+	}
+	if err != nil {
+		queueError(c, err)
+		return nil, false
+	}
+
+	return msg, true
+}
+
+func queueError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, queue.ErrNotPending):
+		c.String(http.StatusConflict, err.Error())
+	case errors.Is(err, queue.ErrSchedule):
+		c.String(http.StatusBadRequest, err.Error())
+	default:
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("queue error: ", err.Error())
+	}
+}
+
+// toMessage converts a queued message. The composed message is only
+// included when withData is set.
+func toMessage(msg *queue.Message, withData bool) *httpclient.Message {
+
+	message := &httpclient.Message{
+		ID:              msg.ID,
+		Status:          string(msg.Status),
+		TemplateGroup:   msg.TemplateGroup,
+		TemplateVersion: msg.TemplateVersion,
+		Variant:         msg.Variant,
+		Backend:         msg.Backend,
+		From:            msg.From,
+		To:              msg.To,
+		Error:           msg.Error,
+		Attempts:        make([]httpclient.Attempt, 0, len(msg.Attempts)),
+		CreatedAt:       msg.CreatedAt,
+		UpdatedAt:       msg.UpdatedAt,
+		DueAt:           msg.DueAt,
+	}
+
+	for _, attempt := range msg.Attempts {
+		message.Attempts = append(message.Attempts, httpclient.Attempt{
+			At:      attempt.At,
+			Backend: attempt.Backend,
+			Code:    attempt.Code,
+			Error:   attempt.Error,
+		})
+	}
+
+	if withData {
+		message.Data = string(msg.Data)
+	}
+
+	return message
+}
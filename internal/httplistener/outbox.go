@@ -0,0 +1,32 @@
+package httplistener
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+)
+
+// ListOutbox returns the messages captured by the memory transport, oldest
+// first.
+func (app *App) ListOutbox(c *gin.Context) {
+
+	if app.outbox == nil {
+		c.String(http.StatusNotImplemented, "memory transport is not configured")
+		return
+	}
+
+	messages := app.outbox.Messages()
+
+	response := make([]*httpclient.CapturedMessage, 0, len(messages))
+	for _, msg := range messages {
+		response = append(response, &httpclient.CapturedMessage{
+			From: msg.From,
+			To:   msg.To,
+			Data: string(msg.Data),
+			At:   msg.At,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
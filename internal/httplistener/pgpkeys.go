@@ -0,0 +1,97 @@
+package httplistener
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+)
+
+func (app *App) GetPGPKey(c *gin.Context) {
+
+	if app.pgpKeys == nil {
+		c.String(http.StatusNotImplemented, "openpgp is not configured")
+		return
+	}
+
+	address := c.Param("address")
+
+	armored, err := app.pgpKeys.Armored(address)
+	if err != nil {
+		if errors.Is(err, pgp.ErrNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("openpgp keys error: ", err.Error())
+		return
+	}
+
+	key, err := app.pgpKeys.Get(address)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("openpgp keys error: ", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, &httpclient.PGPKey{
+		Address:     address,
+		Fingerprint: pgp.Fingerprint(key),
+		Key:         string(armored),
+	})
+}
+
+// PutPGPKey registers or replaces the public key of an address.
+func (app *App) PutPGPKey(c *gin.Context) {
+
+	if app.pgpKeys == nil {
+		c.String(http.StatusNotImplemented, "openpgp is not configured")
+		return
+	}
+
+	body := &httpclient.PGPKey{}
+
+	if err := c.BindJSON(body); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println(err.Error())
+		return
+	}
+
+	address := c.Param("address")
+
+	fingerprint, err := app.pgpKeys.Put(address, []byte(body.Key))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println("openpgp keys error: ", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, &httpclient.PGPKey{
+		Address:     address,
+		Fingerprint: fingerprint,
+		Key:         body.Key,
+	})
+}
+
+func (app *App) DeletePGPKey(c *gin.Context) {
+
+	if app.pgpKeys == nil {
+		c.String(http.StatusNotImplemented, "openpgp is not configured")
+		return
+	}
+
+	if err := app.pgpKeys.Delete(c.Param("address")); err != nil {
+		if errors.Is(err, pgp.ErrNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println("openpgp keys error: ", err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+package httplistener
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/httpclient"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
+)
+
+// ListSuppressions lists the suppressed addresses, limited to the address
+// query parameter when it is set.
+func (app *App) ListSuppressions(c *gin.Context) {
+
+	entries, err := app.suppressions.Entries(c.Query("address"))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("suppressions error: ", err.Error())
+		return
+	}
+
+	response := make([]*httpclient.Suppression, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, toSuppression(entry))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (app *App) AddSuppression(c *gin.Context) {
+
+	body := &httpclient.Suppression{}
+
+	if err := c.BindJSON(body); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println(err.Error())
+		return
+	}
+
+	entry := &suppression.Entry{
+		Address: body.Address,
+		Scope:   body.Scope,
+		Reason:  body.Reason,
+		Source:  suppression.SourceAdmin,
+	}
+
+	if err := app.suppressions.Add(entry); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		log.Println("suppressions error: ", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, toSuppression(entry))
+}
+
+// RemoveSuppression deletes the suppression of an address in the scope
+// query parameter, the global scope when it is not set.
+func (app *App) RemoveSuppression(c *gin.Context) {
+
+	entry, err := app.suppressions.Remove(c.Param("address"), c.Query("scope"))
+	if err != nil {
+		if errors.Is(err, suppression.ErrNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("suppressions error: ", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, toSuppression(entry))
+}
+
+func toSuppression(entry *suppression.Entry) *httpclient.Suppression {
+	return &httpclient.Suppression{
+		Address:   entry.Address,
+		Scope:     entry.Scope,
+		Reason:    entry.Reason,
+		Source:    entry.Source,
+		CreatedAt: entry.CreatedAt,
+	}
+}
@@ -0,0 +1,71 @@
+package httplistener
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucap9056/mail-template-sender/internal/unsubscribe"
+)
+
+// unsubscribePage asks for a confirmation, so that link scanners opening the
+// unsubscribe link do not unsubscribe the recipient.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}<p>You have been unsubscribed.</p>{{else}}<form method="post" action="?token={{.Token}}">
+<p>Do you want to stop receiving these emails?</p>
+<button type="submit" name="List-Unsubscribe" value="One-Click">Unsubscribe</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+type unsubscribePageData struct {
+	Token string
+	Done  bool
+}
+
+func (app *App) UnsubscribePage(c *gin.Context) {
+
+	token := c.Query("token")
+	if token == "" {
+		c.String(http.StatusBadRequest, unsubscribe.ErrInvalidToken.Error())
+		return
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+
+	if err := unsubscribePage.Execute(c.Writer, &unsubscribePageData{Token: token}); err != nil {
+		log.Println("unsubscribe error: ", err.Error())
+	}
+}
+
+// Unsubscribe handles the one-click unsubscribe POST of RFC 8058 as well as
+// the confirmation form of the unsubscribe page.
+func (app *App) Unsubscribe(c *gin.Context) {
+
+	entry, err := app.mailer.Unsubscribe(c.Query("token"))
+	if err != nil {
+		if errors.Is(err, unsubscribe.ErrInvalidToken) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		log.Println("unsubscribe error: ", err.Error())
+		return
+	}
+
+	log.Printf("%s unsubscribed from %q\n", entry.Address, entry.Scope)
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+
+	if err := unsubscribePage.Execute(c.Writer, &unsubscribePageData{Done: true}); err != nil {
+		log.Println("unsubscribe error: ", err.Error())
+	}
+}
@@ -0,0 +1,304 @@
+package lmtp
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/smtp"
+)
+
+const dialTimeout = 10 * time.Second
+
+// ioTimeout bounds the commands of a send, so that a stalled connection
+// cannot hold the client forever. dataTimeout bounds the data of a message
+// and its replies, which may take long as the server delivers to every
+// mailbox first.
+const (
+	ioTimeout   = time.Minute
+	dataTimeout = 10 * time.Minute
+)
+
+type LMTPConfig struct {
+	// Address is a Unix socket path, optionally prefixed with "unix:", or a
+	// TCP host:port, optionally prefixed with "tcp:".
+	Address string
+	// LocalName is sent with LHLO. It defaults to the host name.
+	LocalName string
+}
+
+// LMTP delivers messages to an LMTP server (RFC 2033), such as the local
+// delivery agent of a mailbox system. It keeps one connection, which is
+// replaced on the next send when it failed.
+type LMTP struct {
+	network   string
+	address   string
+	localName string
+	mu        sync.Mutex
+	raw       net.Conn
+	conn      *textproto.Conn
+}
+
+func New(cfg *LMTPConfig) (*LMTP, error) {
+
+	l, err := NewLazy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.connect(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// NewLazy returns a client that connects on its first send.
+func NewLazy(cfg *LMTPConfig) (*LMTP, error) {
+
+	l := &LMTP{
+		localName: cfg.LocalName,
+	}
+
+	switch {
+	case strings.HasPrefix(cfg.Address, "unix:"):
+		l.network, l.address = "unix", strings.TrimPrefix(cfg.Address, "unix:")
+	case strings.HasPrefix(cfg.Address, "tcp:"):
+		l.network, l.address = "tcp", strings.TrimPrefix(cfg.Address, "tcp:")
+	case strings.HasPrefix(cfg.Address, "/"):
+		l.network, l.address = "unix", cfg.Address
+	default:
+		l.network, l.address = "tcp", cfg.Address
+	}
+
+	if l.address == "" {
+		return nil, errors.New("lmtp: no address configured")
+	}
+
+	if l.network == "tcp" {
+		if _, _, err := net.SplitHostPort(l.address); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.localName == "" {
+		l.localName, _ = os.Hostname()
+		if l.localName == "" {
+			l.localName = "localhost"
+		}
+	}
+
+	return l, nil
+}
+
+func (l *LMTP) connect() error {
+
+	conn, err := net.DialTimeout(l.network, l.address, dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	text := textproto.NewConn(conn)
+
+	if _, _, err := text.ReadResponse(220); err != nil {
+		text.Close()
+		return err
+	}
+
+	if err := cmd(text, 2, "LHLO %s", l.localName); err != nil {
+		text.Close()
+		return err
+	}
+
+	l.raw = conn
+	l.conn = text
+
+	return nil
+}
+
+// Send delivers msg to the given recipients. LMTP servers answer the data
+// of a message once per recipient, so a message can be delivered to some
+// recipients and rejected for others; that case is reported as a
+// *smtp.PartialError with one *smtp.RecipientError per rejected recipient.
+func (l *LMTP) Send(from string, to []string, msg []byte) error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		l.raw.SetDeadline(time.Now().Add(ioTimeout))
+
+		// The server may have dropped an idle connection since the last
+		// send.
+		if cmd(l.conn, 2, "RSET") != nil {
+			l.disconnect()
+		}
+	}
+
+	if l.conn == nil {
+		if err := l.connect(); err != nil {
+			return err
+		}
+	}
+
+	err := l.send(from, to, msg)
+
+	// Server replies leave the connection usable; anything else means the
+	// connection is broken and has to be dialed again.
+	var protoErr *textproto.Error
+	if err != nil && !errors.As(err, &protoErr) {
+		l.disconnect()
+	}
+
+	return err
+}
+
+func (l *LMTP) send(from string, to []string, msg []byte) error {
+
+	if err := validateLine(from); err != nil {
+		return err
+	}
+
+	if err := cmd(l.conn, 2, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+
+	accepted := []string{}
+	rejected := []*smtp.RecipientError{}
+
+	for _, target := range to {
+
+		err := validateLine(target)
+		if err == nil {
+			err = cmd(l.conn, 25, "RCPT TO:<%s>", target)
+		}
+
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			rejected = append(rejected, &smtp.RecipientError{Address: target, Err: err})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		accepted = append(accepted, target)
+	}
+
+	if len(accepted) == 0 {
+		return &smtp.PartialError{Rejected: rejected}
+	}
+
+	if err := cmd(l.conn, 354, "DATA"); err != nil {
+		return err
+	}
+
+	l.raw.SetDeadline(time.Now().Add(dataTimeout))
+
+	writer := l.conn.DotWriter()
+
+	if _, err := writer.Write(msg); err != nil {
+		writer.Close()
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	delivered := []string{}
+
+	// One reply follows for every accepted recipient, in RCPT order.
+	for i, target := range accepted {
+
+		_, _, err := l.conn.ReadResponse(2)
+
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			rejected = append(rejected, &smtp.RecipientError{Address: target, Err: err})
+			continue
+		}
+		if err != nil {
+			if len(delivered) == 0 {
+				return err
+			}
+
+			// The recipients that were answered already have the message,
+			// the others are retried over a new connection.
+			l.disconnect()
+
+			for _, target := range accepted[i:] {
+				rejected = append(rejected, &smtp.RecipientError{
+					Address: target,
+					Err:     &textproto.Error{Code: 451, Msg: err.Error()},
+				})
+			}
+
+			return &smtp.PartialError{
+				Accepted: delivered,
+				Rejected: rejected,
+			}
+		}
+
+		delivered = append(delivered, target)
+	}
+
+	if len(rejected) > 0 {
+		return &smtp.PartialError{
+			Accepted: delivered,
+			Rejected: rejected,
+		}
+	}
+
+	return nil
+}
+
+// validateLine rejects an address that would end its command early and
+// inject another, like net/smtp does. The error is a permanent reply, as
+// no retry can deliver to the address.
+func validateLine(address string) error {
+	if strings.ContainsAny(address, "\r\n") {
+		return &textproto.Error{Code: 501, Msg: "lmtp: a line must not contain CR or LF"}
+	}
+	return nil
+}
+
+// cmd sends a command and reads its reply, which has to match expectCode as
+// described by textproto.Reader.ReadResponse.
+func cmd(conn *textproto.Conn, expectCode int, format string, args ...any) error {
+
+	id, err := conn.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+
+	conn.StartResponse(id)
+	defer conn.EndResponse(id)
+
+	_, _, err = conn.ReadResponse(expectCode)
+
+	return err
+}
+
+func (l *LMTP) disconnect() {
+	l.conn.Close()
+	l.raw = nil
+	l.conn = nil
+}
+
+func (l *LMTP) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		l.raw.SetDeadline(time.Now().Add(ioTimeout))
+		cmd(l.conn, 2, "QUIT")
+		l.disconnect()
+	}
+}
@@ -0,0 +1,141 @@
+package lmtp
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lucap9056/mail-template-sender/internal/smtp"
+)
+
+// fakeServer accepts one LMTP connection and records the recipients of the
+// RCPT commands it receives.
+type fakeServer struct {
+	listener   net.Listener
+	mu         sync.Mutex
+	recipients []string
+	done       chan struct{}
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		listener.Close()
+		<-s.done
+	})
+
+	return s
+}
+
+func (s *fakeServer) serve() {
+
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake LMTP")
+
+	accepted := 0
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "RCPT":
+			s.mu.Lock()
+			s.recipients = append(s.recipients, arg)
+			s.mu.Unlock()
+			accepted++
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			if _, err := text.ReadDotBytes(); err != nil {
+				return
+			}
+			for ; accepted > 0; accepted-- {
+				text.PrintfLine("250 delivered")
+			}
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.recipients...)
+}
+
+func TestSendRejectsLineBreaksInAddresses(t *testing.T) {
+
+	server := newFakeServer(t)
+
+	client, err := New(&LMTPConfig{Address: server.listener.Addr().String(), LocalName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	injected := "bob@x>\r\nRCPT TO:<evil@attacker.example"
+
+	err = client.Send("sender@example.com", []string{"alice@example.com", injected}, []byte("Subject: test\r\n\r\nbody\r\n"))
+
+	var partial *smtp.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a partial error, got %v", err)
+	}
+
+	if len(partial.Accepted) != 1 || partial.Accepted[0] != "alice@example.com" {
+		t.Errorf("accepted %v, expected alice@example.com", partial.Accepted)
+	}
+
+	if len(partial.Rejected) != 1 || partial.Rejected[0].Address != injected || smtp.ReplyCode(partial.Rejected[0]) < 500 {
+		t.Errorf("expected the injected address to be rejected permanently, got %v", partial.Rejected)
+	}
+
+	if err := client.Send("sender@x>\r\nRCPT TO:<evil@attacker.example", []string{"alice@example.com"}, []byte("Subject: test\r\n\r\nbody\r\n")); smtp.ReplyCode(err) < 500 {
+		t.Errorf("expected the injected sender to be rejected permanently, got %v", err)
+	}
+
+	client.Close()
+	<-server.done
+
+	for _, recipient := range server.received() {
+		if strings.Contains(recipient, "attacker") {
+			t.Errorf("the server received an injected recipient %q", recipient)
+		}
+	}
+
+	if received := server.received(); len(received) != 1 {
+		t.Errorf("the server received %d recipients, expected 1: %v", len(received), received)
+	}
+}
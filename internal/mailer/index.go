@@ -0,0 +1,1020 @@
+package mailer
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/dkim"
+	"github.com/lucap9056/mail-template-sender/internal/pgp"
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/quota"
+	"github.com/lucap9056/mail-template-sender/internal/ratelimit"
+	"github.com/lucap9056/mail-template-sender/internal/relay"
+	"github.com/lucap9056/mail-template-sender/internal/routing"
+	"github.com/lucap9056/mail-template-sender/internal/smime"
+	"github.com/lucap9056/mail-template-sender/internal/smtp"
+	"github.com/lucap9056/mail-template-sender/internal/suppression"
+	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/unsubscribe"
+)
+
+var ErrSuppressed = errors.New("all recipients are suppressed")
+
+// ErrInvalidAddress is wrapped by the errors of requests with a recipient
+// that is not a plain email address.
+var ErrInvalidAddress = errors.New("invalid address")
+
+type Request struct {
+	TemplateGroup   string
+	TemplateVersion string
+	TemplateNames   []string
+	From            string
+	To              []string
+	Cc              []string
+	Bcc             []string
+	ReplyTo         string
+	Headers         map[string]string
+	Data            any
+	Recipients      []Recipient
+	// SendAt delays the delivery until the given time when set.
+	SendAt time.Time
+	// IdempotencyKey identifies retries of the same request. A request whose
+	// key was seen within the idempotency window is not sent again.
+	IdempotencyKey string
+	// Client identifies the caller for the quota, idempotency keys and the
+	// ownership of its messages.
+	Client string
+	// Tag labels the request for the routing rules.
+	Tag string
+}
+
+// Recipient is a mail merge entry. Each recipient receives an individual
+// message rendered with its own data.
+type Recipient struct {
+	Address string
+	Data    any
+}
+
+type Result struct {
+	MessageID       string
+	To              []string
+	TemplateVersion string
+	Variant         string
+	Err             error
+}
+
+type Config struct {
+	// AllowedHeaders lists the custom headers requests may set.
+	AllowedHeaders []string
+	// From is the default sender of messages whose template group does not
+	// configure one. The SMTP username is used when it has no address.
+	From *mail.Address
+	// AllowedFrom lists the addresses, or "@domain" entries, requests may
+	// use as sender.
+	AllowedFrom []string
+	// EnvelopeFrom is the MAIL FROM address. The SMTP username is used when
+	// it is empty.
+	EnvelopeFrom string
+	// IdempotencyWindow is how long idempotency keys are remembered.
+	IdempotencyWindow time.Duration
+	// Limiter throttles the deliveries. Deliveries are not limited when it
+	// is nil.
+	Limiter *ratelimit.Limiter
+	// Quota caps the messages each client may send. Clients are not limited
+	// when it is nil.
+	Quota *quota.Quota
+	// Suppressions lists the addresses that must not receive mail. It is
+	// checked before every delivery when set, and recipients rejected with a
+	// 5xx reply are added to it.
+	Suppressions *suppression.List
+	// Unsubscribe signs the unsubscribe links of bulk template groups.
+	Unsubscribe *unsubscribe.Signer
+	// SMIME signs and encrypts the rendered messages when set.
+	SMIME *smime.SMIME
+	// Router picks the SMTP backends of each recipient. Messages go through
+	// all backends when it is nil.
+	Router *routing.Router
+	// PGP encrypts the messages of template groups that require OpenPGP.
+	PGP *pgp.PGP
+	// DKIM signs the rendered messages when set.
+	DKIM *dkim.Signer
+}
+
+type Mailer struct {
+	relay          *relay.Relay
+	router         *routing.Router
+	templateGroups *template.TemplateGroups
+	queue          *queue.Queue
+	allowedHeaders map[string]struct{}
+	from           *mail.Address
+	allowedFrom    map[string]struct{}
+	envelopeFrom   string
+	keyWindow      time.Duration
+	limiter        *ratelimit.Limiter
+	quota          *quota.Quota
+	suppressions   *suppression.List
+	unsubscribe    *unsubscribe.Signer
+	smime          *smime.SMIME
+	pgp            *pgp.PGP
+	dkim           *dkim.Signer
+	keysMu         sync.Mutex
+	keys           map[string]*keyLock
+}
+
+// keyLock serializes requests that share an idempotency key.
+type keyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// storedResult is the persisted form of a Result for idempotent replays.
+type storedResult struct {
+	MessageID       string   `json:"message_id"`
+	To              []string `json:"to"`
+	TemplateVersion string   `json:"template_version"`
+	Variant         string   `json:"variant"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// New returns a mailer sending through relay. It fails when there is no
+// default sender, as From has no address and no backend logs in with a
+// username.
+func New(relay *relay.Relay, templateGroups *template.TemplateGroups, queue *queue.Queue, cfg *Config) (*Mailer, error) {
+
+	allowedHeaders := make(map[string]struct{})
+	for _, header := range cfg.AllowedHeaders {
+		allowedHeaders[textproto.CanonicalMIMEHeaderKey(header)] = struct{}{}
+	}
+
+	allowedFrom := make(map[string]struct{})
+	for _, address := range cfg.AllowedFrom {
+		allowedFrom[strings.ToLower(address)] = struct{}{}
+	}
+
+	from := cfg.From
+	if from == nil || from.Address == "" {
+		from = &mail.Address{Address: relay.Username()}
+		if cfg.From != nil {
+			from.Name = cfg.From.Name
+		}
+	}
+
+	if from.Address == "" {
+		return nil, errors.New("no default sender address configured, and no backend has a username to use instead")
+	}
+
+	return &Mailer{
+		relay:          relay,
+		router:         cfg.Router,
+		templateGroups: templateGroups,
+		queue:          queue,
+		allowedHeaders: allowedHeaders,
+		from:           from,
+		allowedFrom:    allowedFrom,
+		envelopeFrom:   cfg.EnvelopeFrom,
+		keyWindow:      cfg.IdempotencyWindow,
+		limiter:        cfg.Limiter,
+		quota:          cfg.Quota,
+		suppressions:   cfg.Suppressions,
+		unsubscribe:    cfg.Unsubscribe,
+		smime:          cfg.SMIME,
+		pgp:            cfg.PGP,
+		dkim:           cfg.DKIM,
+		keys:           make(map[string]*keyLock),
+	}, nil
+}
+
+// Send renders the request and enqueues the messages for delivery. A request
+// with recipients is split into one message per recipient and reports a
+// result for each of them; failures of single recipients are recorded in
+// their result instead of being returned.
+//
+// Requests with an idempotency key that the same client already used return
+// the results of the first request instead.
+func (m *Mailer) Send(req *Request) ([]*Result, error) {
+
+	if req.IdempotencyKey == "" {
+		return m.sendRequest(req)
+	}
+
+	// Keys are scoped to the client, so that clients cannot read each
+	// other's results by guessing keys.
+	key := req.Client + "\n" + req.IdempotencyKey
+
+	unlock := m.lockKey(key)
+	defer unlock()
+
+	results, err := m.recall(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if results != nil {
+		return results, nil
+	}
+
+	results, err = m.sendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.remember(key, results); err != nil {
+		log.Println("idempotency key error: ", err.Error())
+	}
+
+	return results, nil
+}
+
+func (m *Mailer) sendRequest(req *Request) ([]*Result, error) {
+
+	headers, err := m.headers(req.Headers)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers = headers
+
+	from, err := m.sender(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Recipients) != 0 && len(req.To) != 0 {
+		return nil, fmt.Errorf("to and recipients cannot be used together")
+	}
+
+	if len(req.Recipients) != 0 && (len(req.Cc) != 0 || len(req.Bcc) != 0) {
+		return nil, fmt.Errorf("cc and bcc cannot be used with recipients")
+	}
+
+	if err := validateAddresses(req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Recipients) == 0 {
+
+		result, msg := m.render(req, from, req.To, req.Data)
+		if result.Err != nil {
+			return nil, result.Err
+		}
+
+		if err := m.enqueue(req.Client, []*Result{result}, []*queue.Message{msg}); err != nil {
+			return nil, err
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+
+		return []*Result{result}, nil
+	}
+
+	results := make([]*Result, 0, len(req.Recipients))
+	rendered := []*Result{}
+	messages := []*queue.Message{}
+
+	for _, recipient := range req.Recipients {
+
+		data := recipient.Data
+		if data == nil {
+			data = req.Data
+		}
+
+		result, msg := m.render(req, from, []string{recipient.Address}, data)
+		results = append(results, result)
+
+		if result.Err == nil {
+			rendered = append(rendered, result)
+			messages = append(messages, msg)
+		}
+	}
+
+	if err := m.enqueue(req.Client, rendered, messages); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// validateAddresses checks that all recipients of req are plain email
+// addresses and trims them. They go into the envelope, where anything else,
+// such as a line break, would be sent as SMTP commands or retried until
+// the message expires.
+func validateAddresses(req *Request) error {
+
+	for _, addresses := range [][]string{req.To, req.Cc, req.Bcc} {
+		for i, address := range addresses {
+			valid, err := validAddress(address)
+			if err != nil {
+				return err
+			}
+			addresses[i] = valid
+		}
+	}
+
+	for i, recipient := range req.Recipients {
+		valid, err := validAddress(recipient.Address)
+		if err != nil {
+			return err
+		}
+		req.Recipients[i].Address = valid
+	}
+
+	return nil
+}
+
+func validAddress(address string) (string, error) {
+
+	address = strings.TrimSpace(address)
+
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidAddress, address, err)
+	}
+
+	if parsed.Name != "" || parsed.Address != address {
+		return "", fmt.Errorf("%w %q: expected a plain email address", ErrInvalidAddress, address)
+	}
+
+	return address, nil
+}
+
+// enqueue counts the rendered messages against the quota of client and
+// queues them, setting the message ID of their results. Nothing is queued
+// when the quota does not allow all of them, so that requests that fail to
+// render use up no quota.
+func (m *Mailer) enqueue(client string, results []*Result, messages []*queue.Message) error {
+
+	if m.quota != nil && len(messages) > 0 {
+		if err := m.quota.Take(client, len(messages)); err != nil {
+			for _, msg := range messages {
+				m.discard(msg)
+			}
+			return err
+		}
+	}
+
+	for i, msg := range messages {
+
+		if err := m.queue.Enqueue(msg); err != nil {
+			m.discard(msg)
+			results[i].Err = err
+			continue
+		}
+
+		results[i].MessageID = msg.ID
+	}
+
+	return nil
+}
+
+// render reserves and renders the message of a request to to. The message
+// is returned when the result carries no error.
+func (m *Mailer) render(req *Request, from *mail.Address, to []string, data any) (*Result, *queue.Message) {
+
+	result := &Result{
+		To: to,
+	}
+
+	headers, err := m.listHeaders(req, to)
+	if err != nil {
+		result.Err = err
+		return result, nil
+	}
+
+	recipients := make([]string, 0, len(to)+len(req.Cc)+len(req.Bcc))
+	recipients = append(recipients, to...)
+	recipients = append(recipients, req.Cc...)
+	recipients = append(recipients, req.Bcc...)
+
+	msg := &queue.Message{
+		TemplateGroup: req.TemplateGroup,
+		Tag:           req.Tag,
+		Client:        req.Client,
+		From:          m.envelopeFrom,
+		To:            recipients,
+		Bcc:           req.Bcc,
+		DueAt:         req.SendAt,
+	}
+
+	if err := m.queue.Reserve(msg); err != nil {
+		result.Err = err
+		return result, nil
+	}
+
+	rendered, err := m.templateGroups.ToText(&template.Mail{
+		Group:   req.TemplateGroup,
+		Version: req.TemplateVersion,
+		Names:   req.TemplateNames,
+		From:    from.String(),
+		To:      to,
+		Cc:      req.Cc,
+		ReplyTo: req.ReplyTo,
+		Headers: headers,
+		Data:    data,
+	})
+	if err != nil {
+		m.discard(msg)
+		result.Err = err
+		return result, nil
+	}
+
+	var text []byte
+
+	// The keys of an encrypted message name all of its recipients, so the
+	// Bcc recipients get copies encrypted for them alone.
+	if m.encrypts(req.TemplateGroup) {
+		text, msg.Copies, err = m.sealCopies(req.TemplateGroup, rendered.Text, append(slices.Clone(to), req.Cc...), req.Bcc)
+	} else {
+		text, _, err = m.seal(req.TemplateGroup, rendered.Text, recipients)
+	}
+	if err != nil {
+		m.discard(msg)
+		result.Err = err
+		return result, nil
+	}
+
+	result.TemplateVersion = rendered.Version
+	result.Variant = rendered.Variant
+
+	msg.TemplateVersion = rendered.Version
+	msg.Variant = rendered.Variant
+	msg.Data = text
+
+	return result, msg
+}
+
+// sealCopies seals a message for the visible recipients, and a copy for
+// each Bcc recipient. When only some of the visible recipients have an
+// S/MIME certificate, the message is sent unencrypted to the others alone,
+// and those with one get copies encrypted for them.
+func (m *Mailer) sealCopies(groupName string, text []byte, visible []string, bcc []string) ([]byte, map[string][]byte, error) {
+
+	sealed, unencrypted, err := m.seal(groupName, text, visible)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	single := slices.Clone(bcc)
+
+	for _, recipient := range visible {
+		if len(unencrypted) > 0 && !slices.Contains(unencrypted, recipient) {
+			single = append(single, recipient)
+		}
+	}
+
+	if len(single) == 0 {
+		return sealed, nil, nil
+	}
+
+	copies := make(map[string][]byte, len(single))
+
+	for _, recipient := range single {
+		copies[recipient], _, err = m.seal(groupName, text, []string{recipient})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return sealed, copies, nil
+}
+
+// discard deletes the reservation of a message that was not queued, so that
+// invalid requests leave no records behind.
+func (m *Mailer) discard(msg *queue.Message) {
+	if err := m.queue.Discard(msg); err != nil {
+		log.Println("queue update error: ", err.Error())
+	}
+}
+
+// Deliver sends a queued message. It is run by the queue workers. Failures
+// with a 5xx reply are permanent, everything else is retried. Suppressed
+// recipients are skipped, and a message without any other recipient fails
+// permanently.
+func (m *Mailer) Deliver(msg *queue.Message) error {
+
+	to := msg.To
+	if len(msg.Completed) > 0 {
+		to = slices.DeleteFunc(slices.Clone(to), func(address string) bool {
+			return slices.Contains(msg.Completed, address)
+		})
+	}
+
+	if m.suppressions != nil {
+
+		var err error
+		to, err = m.unsuppressed(msg, to)
+		if err != nil {
+			return err
+		}
+
+		if len(to) == 0 {
+			return &queue.DeliveryError{
+				Permanent: true,
+				Err:       ErrSuppressed,
+			}
+		}
+	}
+
+	if m.limiter != nil {
+		release, retryAt, ok := m.limiter.Acquire(to)
+		if !ok {
+			return &queue.DeferError{
+				Until:  retryAt,
+				Reason: "rate limited",
+			}
+		}
+		defer release()
+	}
+
+	var temporary, permanent error
+	backends := []string{}
+
+	// Recipients routed to other backends are sent separately. A message
+	// is retried while a route failed temporarily; the routes that went
+	// through or failed permanently are marked completed.
+	for _, route := range m.route(msg, to) {
+
+		for _, envelope := range envelopes(msg, route.Recipients) {
+
+			names, retryAt, ok := m.accounts(route.Backends)
+			if !ok {
+				temporary = cmp.Or(temporary, error(&queue.DeferError{
+					Until:  retryAt,
+					Reason: "rate limited",
+				}))
+				continue
+			}
+
+			backend, err := m.relay.Send(names, msg.From, envelope.to, envelope.data)
+			backends = append(backends, backend)
+
+			if m.limiter != nil && backend != "" {
+				m.limiter.Charge(backend)
+			}
+
+			if err == nil {
+				msg.Completed = append(msg.Completed, envelope.to...)
+				continue
+			}
+
+			err = m.failed(msg, err)
+
+			var deliveryErr *queue.DeliveryError
+			if errors.As(err, &deliveryErr) && deliveryErr.Permanent {
+				// The recipients of a route that failed for good are done
+				// with, so that retrying the other routes skips them.
+				for _, recipient := range envelope.to {
+					if !slices.Contains(msg.Completed, recipient) {
+						msg.Completed = append(msg.Completed, recipient)
+					}
+				}
+				permanent = cmp.Or(permanent, err)
+			} else {
+				temporary = cmp.Or(temporary, err)
+			}
+		}
+	}
+
+	msg.Backend = strings.Join(backends, ",")
+
+	return cmp.Or(temporary, permanent)
+}
+
+// accounts returns the backends among names, or among all backends when
+// names is empty, whose account limits allow another message. When none
+// does, the time at which to try again is returned.
+func (m *Mailer) accounts(names []string) ([]string, time.Time, bool) {
+
+	if m.limiter == nil {
+		return names, time.Time{}, true
+	}
+
+	if len(names) == 0 {
+		names = m.relay.Names()
+	}
+
+	available, retryAt := m.limiter.Accounts(names)
+
+	return available, retryAt, len(available) > 0
+}
+
+// envelope is the data of a message sent to some of its recipients.
+type envelope struct {
+	to   []string
+	data []byte
+}
+
+// envelopes splits recipients into the ones that share the data of msg and
+// the ones that get a copy of their own.
+func envelopes(msg *queue.Message, recipients []string) []*envelope {
+
+	shared := &envelope{data: msg.Data}
+	envelopes := []*envelope{}
+
+	for _, recipient := range recipients {
+		if data, exists := msg.Copies[recipient]; exists {
+			envelopes = append(envelopes, &envelope{to: []string{recipient}, data: data})
+		} else {
+			shared.to = append(shared.to, recipient)
+		}
+	}
+
+	if len(shared.to) > 0 {
+		envelopes = append([]*envelope{shared}, envelopes...)
+	}
+
+	return envelopes
+}
+
+// failed turns the error of a send into the error of the delivery attempt.
+func (m *Mailer) failed(msg *queue.Message, err error) error {
+
+	var partial *smtp.PartialError
+	if errors.As(err, &partial) {
+		return m.partial(msg, partial)
+	}
+
+	code := smtp.ReplyCode(err)
+
+	var rcptErr *smtp.RecipientError
+	if code >= 500 && errors.As(err, &rcptErr) {
+		m.bounce(rcptErr)
+	}
+
+	return &queue.DeliveryError{
+		Code:      code,
+		Permanent: code >= 500,
+		Err:       err,
+	}
+}
+
+// partial records a delivery that succeeded for some recipients only. The
+// recipients that accepted or permanently rejected the message are marked
+// completed, and the message is retried for the others.
+func (m *Mailer) partial(msg *queue.Message, partial *smtp.PartialError) error {
+
+	msg.Completed = append(msg.Completed, partial.Accepted...)
+
+	var temporary *smtp.RecipientError
+
+	for _, rcptErr := range partial.Rejected {
+
+		if smtp.ReplyCode(rcptErr) >= 500 {
+			msg.Completed = append(msg.Completed, rcptErr.Address)
+			m.bounce(rcptErr)
+			continue
+		}
+
+		if temporary == nil {
+			temporary = rcptErr
+		}
+	}
+
+	if temporary != nil {
+		return &queue.DeliveryError{
+			Code: smtp.ReplyCode(temporary),
+			Err:  partial,
+		}
+	}
+
+	return &queue.DeliveryError{
+		Code:      smtp.ReplyCode(partial),
+		Permanent: true,
+		Err:       partial,
+	}
+}
+
+// bounce suppresses a recipient that was rejected with a 5xx reply.
+func (m *Mailer) bounce(rcptErr *smtp.RecipientError) {
+
+	if m.suppressions == nil {
+		return
+	}
+
+	err := m.suppressions.Add(&suppression.Entry{
+		Address: rcptErr.Address,
+		Reason:  rcptErr.Err.Error(),
+		Source:  suppression.SourceBounce,
+	})
+	if err != nil {
+		log.Println("suppression error: ", err.Error())
+	}
+}
+
+// route splits the recipients to of a message by their SMTP backends. All
+// recipients go through all backends when there is no router.
+func (m *Mailer) route(msg *queue.Message, to []string) []*routing.Route {
+
+	if m.router == nil {
+		return []*routing.Route{{Recipients: to}}
+	}
+
+	attributes := &routing.Message{
+		Group:      msg.TemplateGroup,
+		Tag:        msg.Tag,
+		Recipients: to,
+	}
+
+	if group, exists := m.templateGroups.Group(msg.TemplateGroup); exists {
+		attributes.Category = group.Category()
+	}
+
+	return m.router.Route(attributes)
+}
+
+// unsuppressed returns the recipients among to that are not suppressed for
+// the template group of msg or the category of the group.
+func (m *Mailer) unsuppressed(msg *queue.Message, to []string) ([]string, error) {
+
+	scopes := []string{msg.TemplateGroup}
+	if group, exists := m.templateGroups.Group(msg.TemplateGroup); exists {
+		scopes = append(scopes, group.Category())
+	}
+
+	remaining := make([]string, 0, len(to))
+
+	for _, address := range to {
+
+		entry, err := m.suppressions.Suppressed(address, scopes...)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil {
+			log.Printf("message %s skips suppressed recipient %s\n", msg.ID, address)
+			continue
+		}
+
+		remaining = append(remaining, address)
+	}
+
+	return remaining, nil
+}
+
+func (m *Mailer) lockKey(key string) func() {
+
+	m.keysMu.Lock()
+	lock, exists := m.keys[key]
+	if !exists {
+		lock = &keyLock{}
+		m.keys[key] = lock
+	}
+	lock.waiters++
+	m.keysMu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		m.keysMu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(m.keys, key)
+		}
+		m.keysMu.Unlock()
+	}
+}
+
+func (m *Mailer) remember(key string, results []*Result) error {
+
+	stored := make([]storedResult, 0, len(results))
+
+	for _, result := range results {
+
+		entry := storedResult{
+			MessageID:       result.MessageID,
+			To:              result.To,
+			TemplateVersion: result.TemplateVersion,
+			Variant:         result.Variant,
+		}
+
+		if result.Err != nil {
+			entry.Error = result.Err.Error()
+		}
+
+		stored = append(stored, entry)
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	return m.queue.Remember(key, value, time.Now().Add(m.keyWindow))
+}
+
+// recall returns the results remembered for key, or nil when there are
+// none.
+func (m *Mailer) recall(key string) ([]*Result, error) {
+
+	value, err := m.queue.Recall(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	stored := []storedResult{}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(stored))
+
+	for _, entry := range stored {
+
+		result := &Result{
+			MessageID:       entry.MessageID,
+			To:              entry.To,
+			TemplateVersion: entry.TemplateVersion,
+			Variant:         entry.Variant,
+		}
+
+		if entry.Error != "" {
+			result.Err = errors.New(entry.Error)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// headers canonicalizes the custom headers of a request and rejects the ones
+// that are not allowed.
+func (m *Mailer) headers(headers map[string]string) (map[string]string, error) {
+
+	canonical := make(map[string]string, len(headers))
+
+	for key, value := range headers {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if _, allowed := m.allowedHeaders[key]; !allowed {
+			return nil, fmt.Errorf("header not allowed: %s", key)
+		}
+		canonical[key] = value
+	}
+
+	return canonical, nil
+}
+
+// seal applies OpenPGP or S/MIME and then DKIM to a rendered message, as
+// DKIM has to sign the final content. Messages of groups that require
+// OpenPGP are not sealed with S/MIME once they are encrypted. Messages that
+// are sent unencrypted as a recipient has no key or certificate are logged,
+// and when only some recipients have an S/MIME certificate, those without
+// one are returned.
+func (m *Mailer) seal(groupName string, text []byte, recipients []string) ([]byte, []string, error) {
+
+	var err error
+	var missing []string
+	encrypted := false
+
+	if m.requiresPGP(groupName) {
+
+		if m.pgp == nil {
+			return nil, nil, fmt.Errorf("template group %s requires openpgp, which is not configured", groupName)
+		}
+
+		text, encrypted, err = m.pgp.Seal(text, recipients)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !encrypted {
+			log.Printf("openpgp: sending a message of group %s unencrypted, as a recipient has no key\n", groupName)
+		}
+	}
+
+	if m.smime != nil && !encrypted {
+
+		text, missing, err = m.smime.Seal(text, recipients)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(missing) > 0 {
+			log.Printf("smime: sending a message of group %s unencrypted to %d of its recipients, as they have no certificate\n", groupName, len(missing))
+		}
+	}
+
+	if m.dkim != nil {
+		text, err = m.dkim.Sign(text)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return text, missing, nil
+}
+
+// requiresPGP reports whether the messages of a template group are
+// encrypted with OpenPGP.
+func (m *Mailer) requiresPGP(groupName string) bool {
+	group, exists := m.templateGroups.Group(groupName)
+	return exists && group.Encrypt() == template.EncryptPGP
+}
+
+// encrypts reports whether the messages of a template group may be
+// encrypted, so that the encrypted copy names its recipients.
+func (m *Mailer) encrypts(groupName string) bool {
+	return m.requiresPGP(groupName) || (m.smime != nil && m.smime.Encrypts())
+}
+
+// listHeaders returns the headers of a message to to, with the one-click
+// unsubscribe headers of RFC 8058 added for bulk template groups.
+func (m *Mailer) listHeaders(req *Request, to []string) (map[string]string, error) {
+
+	group, exists := m.templateGroups.Group(req.TemplateGroup)
+	if !exists || !group.Bulk() {
+		return req.Headers, nil
+	}
+
+	if m.unsubscribe == nil || m.suppressions == nil {
+		return nil, errors.New("unsubscribe is not configured for bulk template groups")
+	}
+
+	if len(to) != 1 {
+		return nil, errors.New("bulk messages must have a single recipient")
+	}
+
+	// The unsubscribe link is made for to, so copies would let other
+	// recipients unsubscribe it.
+	if len(req.Cc) != 0 || len(req.Bcc) != 0 {
+		return nil, errors.New("bulk messages cannot have cc or bcc recipients")
+	}
+
+	headers := make(map[string]string, len(req.Headers)+2)
+	for key, value := range req.Headers {
+		headers[key] = value
+	}
+
+	headers["List-Unsubscribe"] = "<" + m.unsubscribe.URL(to[0], group.Category()) + ">"
+	headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+
+	return headers, nil
+}
+
+// Unsubscribe suppresses the address carried by an unsubscribe token for the
+// scope of the token.
+func (m *Mailer) Unsubscribe(token string) (*suppression.Entry, error) {
+
+	if m.unsubscribe == nil || m.suppressions == nil {
+		return nil, errors.New("unsubscribe is not configured")
+	}
+
+	address, scope, err := m.unsubscribe.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &suppression.Entry{
+		Address: address,
+		Scope:   scope,
+		Reason:  "unsubscribed",
+		Source:  suppression.SourceUnsubscribe,
+	}
+
+	if err := m.suppressions.Add(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// sender resolves the From address of a request. A sender requested by the
+// caller must be allowed by the configuration, otherwise the sender of the
+// template group or the default sender is used.
+func (m *Mailer) sender(req *Request) (*mail.Address, error) {
+
+	if req.From == "" {
+		if group, exists := m.templateGroups.Group(req.TemplateGroup); exists && group.From() != nil {
+			return group.From(), nil
+		}
+		return m.from, nil
+	}
+
+	from, err := mail.ParseAddress(req.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %v", err)
+	}
+
+	address := strings.ToLower(from.Address)
+	domain := address[strings.LastIndex(address, "@"):]
+
+	if _, allowed := m.allowedFrom[address]; allowed {
+		return from, nil
+	}
+
+	if _, allowed := m.allowedFrom[domain]; allowed {
+		return from, nil
+	}
+
+	return nil, fmt.Errorf("from address not allowed: %s", from.Address)
+}
@@ -0,0 +1,277 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"github.com/lucap9056/mail-template-sender/internal/relay"
+	"github.com/lucap9056/mail-template-sender/internal/routing"
+	"github.com/lucap9056/mail-template-sender/internal/smime"
+	"github.com/lucap9056/mail-template-sender/internal/template"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
+)
+
+// newTestDeps returns a template group "welcome" with the template
+// "welcome.html" and a queue, both in a temporary directory.
+func newTestDeps(t *testing.T) (*template.TemplateGroups, *queue.Queue) {
+
+	dir := t.TempDir()
+
+	group := filepath.Join(dir, "templates", "welcome")
+	if err := os.MkdirAll(group, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	page := `<html><head><title>Welcome</title></head><body>Hello</body></html>`
+	if err := os.WriteFile(filepath.Join(group, "welcome.html"), []byte(page), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := template.New(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := queue.New(filepath.Join(dir, "queue.db"), &queue.RetryPolicy{InitialInterval: time.Minute}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { messages.Close() })
+
+	return templates, messages
+}
+
+// TestNewRequiresSender builds the mailer as cmd/main.go does for the
+// memory transport, which has no username to send as.
+func TestNewRequiresSender(t *testing.T) {
+
+	templates, messages := newTestDeps(t)
+
+	outbox := transport.NewMemory(0)
+
+	backends, err := relay.New([]*relay.Backend{{Name: "default", Transport: outbox}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(backends, templates, messages, &Config{
+		From: &mail.Address{Name: "Example", Address: ""},
+	})
+	if err == nil {
+		t.Fatal("expected an error without a sender address")
+	}
+
+	m, err := New(backends, templates, messages, &Config{
+		From: &mail.Address{Name: "Example", Address: "noreply@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages.Start(1, m.Deliver)
+
+	if _, err := m.Send(&Request{
+		TemplateGroup: "welcome",
+		TemplateNames: []string{"welcome.html"},
+		To:            []string{"alice@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(outbox.Messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the message was not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if data := string(outbox.Messages()[0].Data); !strings.Contains(data, `From: "Example" <noreply@example.com>`) {
+		t.Errorf("unexpected sender in:\n%s", data)
+	}
+}
+
+// TestSMIMEEncryptsForRecipientsWithCertificates sends a message to a
+// recipient with a certificate and one without, which must not make the
+// message go out unencrypted to both.
+func TestSMIMEEncryptsForRecipientsWithCertificates(t *testing.T) {
+
+	templates, messages := newTestDeps(t)
+
+	certificates := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice@example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice@example.com"},
+	}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(certificates, "alice@example.com.pem"), certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sealer, err := smime.New(&smime.Config{RecipientsDirectory: certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := transport.NewMemory(0)
+
+	backends, err := relay.New([]*relay.Backend{{Name: "default", Transport: outbox}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(backends, templates, messages, &Config{
+		From:  &mail.Address{Address: "noreply@example.com"},
+		SMIME: sealer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages.Start(1, m.Deliver)
+
+	if _, err := m.Send(&Request{
+		TemplateGroup: "welcome",
+		TemplateNames: []string{"welcome.html"},
+		To:            []string{"alice@example.com"},
+		Cc:            []string{"bob@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(outbox.Messages()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 2 messages were delivered", len(outbox.Messages()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, sent := range outbox.Messages() {
+
+		if len(sent.To) != 1 {
+			t.Fatalf("a message was sent to %v, expected a single recipient", sent.To)
+		}
+
+		encrypted := strings.Contains(string(sent.Data), "application/pkcs7-mime")
+
+		switch sent.To[0] {
+		case "alice@example.com":
+			if !encrypted {
+				t.Errorf("the message to alice@example.com is not encrypted")
+			}
+		case "bob@example.com":
+			if encrypted {
+				t.Errorf("the message to bob@example.com is encrypted without a certificate")
+			}
+		default:
+			t.Errorf("unexpected recipient %s", sent.To[0])
+		}
+	}
+}
+
+// failingTransport answers every message with the reply code, until the
+// code is set to 0.
+type failingTransport struct {
+	code  int
+	calls int
+}
+
+func (f *failingTransport) Send(from string, to []string, msg []byte) error {
+	f.calls++
+	if f.code == 0 {
+		return nil
+	}
+	return &textproto.Error{Code: f.code, Msg: "failing transport"}
+}
+
+func (f *failingTransport) Close() {}
+
+// TestDeliverMixedRoutes delivers a message whose recipients are routed to
+// a backend that rejects them for good and one that fails temporarily.
+func TestDeliverMixedRoutes(t *testing.T) {
+
+	templates, messages := newTestDeps(t)
+
+	rejecting := &failingTransport{code: 550}
+	busy := &failingTransport{code: 451}
+
+	backends, err := relay.New([]*relay.Backend{
+		{Name: "rejecting", Transport: rejecting},
+		{Name: "busy", Transport: busy},
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := routing.ParseRules("domain:bounce.example=rejecting,*=busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := routing.New(rules, backends.Names())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(backends, templates, messages, &Config{
+		From:   &mail.Address{Address: "noreply@example.com"},
+		Router: router,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &queue.Message{
+		ID:   "mixed",
+		To:   []string{"alice@bounce.example", "bob@example.com"},
+		Data: []byte("Subject: test\r\n\r\nbody\r\n"),
+	}
+
+	var deliveryErr *queue.DeliveryError
+	if err := m.Deliver(msg); !errors.As(err, &deliveryErr) || deliveryErr.Permanent {
+		t.Fatalf("expected a temporary error, got %v", err)
+	}
+
+	if len(msg.Completed) != 1 || msg.Completed[0] != "alice@bounce.example" {
+		t.Fatalf("completed %v, expected the rejected recipient", msg.Completed)
+	}
+
+	busy.code = 0
+
+	if err := m.Deliver(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if rejecting.calls != 1 {
+		t.Errorf("the rejecting backend was called %d times, expected once", rejecting.calls)
+	}
+}
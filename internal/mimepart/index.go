@@ -0,0 +1,107 @@
+package mimepart
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// Split separates a rendered message into its top level header, without the
+// MIME-Version and Content-* fields, and the MIME entity of its content. The
+// entity carries the content fields and a quoted-printable body, so that it
+// survives transports that are not 8-bit clean. Lines end with "\n".
+func Split(msg []byte) ([]byte, []byte, error) {
+
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+
+	head, body, _ := bytes.Cut(msg, []byte("\n\n"))
+
+	var header bytes.Buffer
+	var entity bytes.Buffer
+
+	for _, field := range fields(string(head)) {
+
+		name, value, _ := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+
+		switch {
+		case key == "mime-version", key == "content-transfer-encoding":
+		case strings.HasPrefix(key, "content-"):
+			// The template package ends the content fields with ";".
+			entity.WriteString(name + ": " + strings.TrimSuffix(strings.TrimSpace(value), ";") + "\n")
+		default:
+			header.WriteString(field + "\n")
+		}
+	}
+
+	if entity.Len() == 0 {
+		entity.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\n")
+	}
+
+	entity.WriteString("Content-Transfer-Encoding: quoted-printable\n\n")
+
+	var encoded bytes.Buffer
+	writer := quotedprintable.NewWriter(&encoded)
+	if _, err := writer.Write(body); err != nil {
+		return nil, nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	entity.Write(bytes.ReplaceAll(encoded.Bytes(), []byte("\r\n"), []byte("\n")))
+
+	return header.Bytes(), bytes.TrimRight(entity.Bytes(), "\n"), nil
+}
+
+// fields returns the header fields of head with their continuation lines.
+func fields(head string) []string {
+
+	list := []string{}
+
+	for _, line := range strings.Split(head, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(list) > 0 {
+			list[len(list)-1] += "\n" + line
+			continue
+		}
+		if line != "" {
+			list = append(list, line)
+		}
+	}
+
+	return list
+}
+
+// CRLF converts the line endings of b to CRLF, the canonical form that is
+// signed and encrypted.
+func CRLF(b []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// Base64 encodes data in lines of 76 characters.
+func Base64(data []byte) []byte {
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var lines bytes.Buffer
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\n")
+
+	return lines.Bytes()
+}
+
+// Boundary returns a random multipart boundary.
+func Boundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "=_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,157 @@
+package pgp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/lucap9056/mail-template-sender/internal/mimepart"
+)
+
+// Fallback policies for recipients without a key.
+const (
+	// FallbackFail fails the message.
+	FallbackFail = "fail"
+	// FallbackSend sends the message unencrypted.
+	FallbackSend = "send"
+)
+
+type Config struct {
+	Keys *KeyStore
+	// Signer signs the encrypted messages when it is set.
+	Signer *openpgp.Entity
+	// Fallback is the policy for messages with a recipient without a key.
+	Fallback string
+}
+
+type PGP struct {
+	cfg *Config
+}
+
+func New(cfg *Config) (*PGP, error) {
+
+	switch cfg.Fallback {
+	case FallbackFail, FallbackSend:
+	default:
+		return nil, fmt.Errorf("openpgp: unknown fallback policy %q", cfg.Fallback)
+	}
+
+	return &PGP{cfg: cfg}, nil
+}
+
+// LoadSigner reads an armored private key, decrypting it with passphrase
+// when it is protected.
+func LoadSigner(path string, passphrase string) (*openpgp.Entity, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := readKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.PrivateKey == nil {
+		return nil, fmt.Errorf("openpgp: %s holds no private key", path)
+	}
+
+	if key.PrivateKey.Encrypted {
+		if err := key.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, subkey := range key.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return key, nil
+}
+
+// Seal encrypts msg to the keys of all recipients as PGP/MIME (RFC 3156).
+// When a recipient has no key, msg is returned unchanged under the send
+// policy and an error is returned under the fail policy. The boolean
+// reports whether msg was encrypted.
+func (p *PGP) Seal(msg []byte, recipients []string) ([]byte, bool, error) {
+
+	keys := make([]*openpgp.Entity, 0, len(recipients))
+
+	for _, recipient := range recipients {
+
+		key, err := p.cfg.Keys.Get(recipient)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if key == nil {
+			if p.cfg.Fallback == FallbackSend {
+				return msg, false, nil
+			}
+			return nil, false, fmt.Errorf("no openpgp key for %s", recipient)
+		}
+
+		keys = append(keys, key)
+	}
+
+	header, entity, err := mimepart.Split(msg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var encrypted bytes.Buffer
+
+	armored, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The binary literal keeps the CRLF line endings of the MIME entity.
+	plaintext, err := openpgp.Encrypt(armored, keys, p.cfg.Signer, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, err := plaintext.Write(mimepart.CRLF(entity)); err != nil {
+		return nil, false, err
+	}
+
+	if err := plaintext.Close(); err != nil {
+		return nil, false, err
+	}
+
+	if err := armored.Close(); err != nil {
+		return nil, false, err
+	}
+
+	boundary, err := mimepart.Boundary()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var sealed bytes.Buffer
+
+	sealed.Write(header)
+	sealed.WriteString("MIME-Version: 1.0\n")
+	fmt.Fprintf(&sealed, "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"%s\"\n\n", boundary)
+	sealed.WriteString("This is an OpenPGP/MIME encrypted message.\n\n")
+	fmt.Fprintf(&sealed, "--%s\n", boundary)
+	sealed.WriteString("Content-Type: application/pgp-encrypted\n")
+	sealed.WriteString("Content-Description: PGP/MIME version identification\n\n")
+	sealed.WriteString("Version: 1\n\n")
+	fmt.Fprintf(&sealed, "--%s\n", boundary)
+	sealed.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\n")
+	sealed.WriteString("Content-Description: OpenPGP encrypted message\n")
+	sealed.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\n\n")
+	sealed.Write(encrypted.Bytes())
+	fmt.Fprintf(&sealed, "\n\n--%s--", boundary)
+
+	return sealed.Bytes(), true, nil
+}
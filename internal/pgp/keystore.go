@@ -0,0 +1,158 @@
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+var ErrNotFound = errors.New("openpgp key not found")
+
+// KeyStore keeps the armored public keys of the recipients in a directory,
+// one "<address>.asc" file per recipient.
+type KeyStore struct {
+	dir string
+}
+
+func NewKeyStore(dir string) (*KeyStore, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &KeyStore{dir: dir}, nil
+}
+
+// Get returns the key of address, or nil when none is registered.
+func (s *KeyStore) Get(address string) (*openpgp.Entity, error) {
+
+	armored, err := s.Armored(address)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return readKey(armored)
+}
+
+// Armored returns the armored key of address.
+func (s *KeyStore) Armored(address string) ([]byte, error) {
+
+	path, err := s.path(address)
+	if err != nil {
+		return nil, err
+	}
+
+	armored, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return armored, nil
+}
+
+// Put registers the armored public key of address and returns its
+// fingerprint. Keys that cannot be encrypted to, or that have no user ID
+// with the address, are rejected.
+func (s *KeyStore) Put(address string, armored []byte) (string, error) {
+
+	path, err := s.path(address)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := readKey(armored)
+	if err != nil {
+		return "", err
+	}
+
+	if key.PrivateKey != nil {
+		return "", errors.New("openpgp: expected a public key")
+	}
+
+	if !hasAddress(key, address) {
+		return "", fmt.Errorf("openpgp: the key has no user id for %s", strings.TrimSpace(address))
+	}
+
+	plaintext, err := openpgp.Encrypt(io.Discard, []*openpgp.Entity{key}, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	plaintext.Close()
+
+	if err := os.WriteFile(path, armored, 0600); err != nil {
+		return "", err
+	}
+
+	return Fingerprint(key), nil
+}
+
+// Delete removes the key of address.
+func (s *KeyStore) Delete(address string) error {
+
+	path, err := s.path(address)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (s *KeyStore) path(address string) (string, error) {
+
+	address = strings.ToLower(strings.TrimSpace(address))
+
+	if address == "" || strings.ContainsAny(address, `/\`) || strings.HasPrefix(address, ".") {
+		return "", fmt.Errorf("openpgp: invalid address %q", address)
+	}
+
+	return filepath.Join(s.dir, address+".asc"), nil
+}
+
+// hasAddress reports whether a user ID of key has the email address.
+func hasAddress(key *openpgp.Entity, address string) bool {
+
+	for _, identity := range key.Identities {
+		if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, strings.TrimSpace(address)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fingerprint returns the hex encoded fingerprint of the primary key.
+func Fingerprint(key *openpgp.Entity) string {
+	return fmt.Sprintf("%X", key.PrimaryKey.Fingerprint)
+}
+
+// readKey parses a single armored key.
+func readKey(armored []byte) (*openpgp.Entity, error) {
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("openpgp: expected one key, found %d", len(keys))
+	}
+
+	return keys[0], nil
+}
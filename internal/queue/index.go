@@ -0,0 +1,1003 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	mathrand "math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	messagesBucket    = []byte("messages")
+	pendingBucket     = []byte("pending")
+	deadLettersBucket = []byte("dead_letters")
+	keysBucket        = []byte("idempotency_keys")
+)
+
+var (
+	ErrNotFound   = errors.New("message not found")
+	ErrNotPending = errors.New("message is not pending")
+	ErrSchedule   = errors.New("send time is out of range")
+)
+
+// MaxSchedule is how far ahead a message may be scheduled.
+const MaxSchedule = 366 * 24 * time.Hour
+
+// CheckSchedule returns ErrSchedule when at is before 1970 or more than
+// MaxSchedule ahead. The pending index orders the messages by their due
+// time in unsigned nanoseconds since 1970, which other times overflow.
+func CheckSchedule(at time.Time) error {
+	if at.Before(time.Unix(0, 0)) || at.After(time.Now().Add(MaxSchedule)) {
+		return ErrSchedule
+	}
+	return nil
+}
+
+// idleInterval bounds how long an idle worker waits before looking for due
+// messages again.
+const idleInterval = 5 * time.Second
+
+// purgeInterval is how often expired idempotency keys and messages past
+// their retention are deleted.
+const purgeInterval = 10 * time.Minute
+
+// maxBackoff bounds retry intervals without a MaxInterval, so that due times
+// stay within the range of the pending index.
+const maxBackoff = 365 * 24 * time.Hour
+
+type Status string
+
+const (
+	StatusRendering Status = "rendering"
+	StatusQueued    Status = "queued"
+	StatusSending   Status = "sending"
+	StatusSent      Status = "sent"
+	StatusDeferred  Status = "deferred"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Attempt records the outcome of one delivery attempt. Code is the SMTP reply
+// code, or 0 when the server did not reply.
+type Attempt struct {
+	At      time.Time `json:"at"`
+	Backend string    `json:"backend,omitempty"`
+	Code    int       `json:"code"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// DeliveryError is returned by a DeliverFunc to describe a failed attempt.
+// Permanent failures are not retried. Other errors are treated as transient
+// failures without a reply code.
+type DeliveryError struct {
+	Code      int
+	Permanent bool
+	Err       error
+}
+
+func (err *DeliveryError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *DeliveryError) Unwrap() error {
+	return err.Err
+}
+
+// DeferError is returned by a DeliverFunc when a message could not be
+// attempted yet, for example because of a rate limit. The message is moved
+// back to the queue until a random time between Until and twice as far
+// from now, so that deferred messages do not all come back at once. It does
+// not count as a failed attempt and is not published, but the message still
+// fails once it has been queued for longer than MaxAge.
+type DeferError struct {
+	Until  time.Time
+	Reason string
+}
+
+func (err *DeferError) Error() string {
+	return err.Reason
+}
+
+// RetryPolicy controls how transient failures are retried. Messages are
+// moved to the dead letters once MaxAttempts attempts were made or they
+// have been queued for longer than MaxAge. Zero limits are not enforced.
+type RetryPolicy struct {
+	MaxAttempts     int
+	MaxAge          time.Duration
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter randomizes each interval by up to this fraction of it.
+	Jitter float64
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of failed attempts.
+func (policy *RetryPolicy) Backoff(attempts int) time.Duration {
+
+	interval := float64(policy.InitialInterval) * math.Pow(policy.Multiplier, float64(attempts-1))
+	if policy.MaxInterval > 0 {
+		interval = math.Min(interval, float64(policy.MaxInterval))
+	}
+
+	interval += interval * policy.Jitter * (2*mathrand.Float64() - 1)
+
+	// Without a maximum the interval grows to +Inf, which has no Duration.
+	if math.IsNaN(interval) || interval > float64(maxBackoff) {
+		return maxBackoff
+	}
+
+	return time.Duration(interval)
+}
+
+// Message is a queued message. Client identifies the caller that sent it,
+// who may look it up and change it. To lists all envelope recipients, and Bcc
+// the ones among them that are hidden from the other recipients. Backend
+// names the SMTP backend that handled the last attempt. Completed lists the
+// recipients that need no further attempt after a partial delivery, as they
+// accepted or permanently rejected the message. Copies holds the data sent
+// to the Bcc recipients that get a copy of their own, such as one encrypted
+// for them alone.
+type Message struct {
+	ID              string            `json:"id"`
+	Status          Status            `json:"status"`
+	TemplateGroup   string            `json:"template_group"`
+	TemplateVersion string            `json:"template_version"`
+	Variant         string            `json:"variant"`
+	Tag             string            `json:"tag,omitempty"`
+	Client          string            `json:"client,omitempty"`
+	Backend         string            `json:"backend,omitempty"`
+	From            string            `json:"from"`
+	To              []string          `json:"to"`
+	Bcc             []string          `json:"bcc,omitempty"`
+	Completed       []string          `json:"completed,omitempty"`
+	Data            []byte            `json:"data"`
+	Copies          map[string][]byte `json:"copies,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Attempts        []Attempt         `json:"attempts,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	DueAt           time.Time         `json:"due_at"`
+	// QueuedAt and Retries count from the last time the message was queued
+	// or became due, so that requeued dead letters and scheduled messages
+	// get a fresh retry budget.
+	QueuedAt time.Time `json:"queued_at"`
+	Retries  int       `json:"retries"`
+}
+
+// Event describes a status change of a message. To leaves out the Bcc
+// recipients, as events are passed on to webhooks. Client is the sender of
+// the message and is not passed on.
+type Event struct {
+	MessageID     string    `json:"message_id"`
+	Client        string    `json:"-"`
+	Status        Status    `json:"status"`
+	TemplateGroup string    `json:"template_group"`
+	To            []string  `json:"to"`
+	Code          int       `json:"code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// DeliverFunc hands a message over to its transport.
+type DeliverFunc func(msg *Message) error
+
+// Queue is a persistent outbound queue stored in a bbolt database. Messages
+// waiting for delivery are indexed by their due time in the pending bucket.
+type Queue struct {
+	db          *bbolt.DB
+	retry       *RetryPolicy
+	retention   time.Duration
+	wake        chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	subscribers map[uint64]func(event *Event)
+	nextID      uint64
+}
+
+// New opens the queue stored at path. Sent, failed and cancelled messages
+// are deleted once they have not changed for retention, or kept forever
+// when retention is zero.
+func New(path string, retry *RetryPolicy, retention time.Duration) (*Queue, error) {
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		db:          db,
+		retry:       retry,
+		retention:   retention,
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[uint64]func(event *Event)),
+	}
+
+	if err := q.recover(); err != nil {
+		db.Close()
+		cancel()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// recover puts messages that were being sent when the process stopped back
+// into the pending index. Messages that were still being rendered are
+// deleted, as their content was never stored and their request did not
+// complete.
+func (q *Queue) recover() error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+
+		messages, err := tx.CreateBucketIfNotExists(messagesBucket)
+		if err != nil {
+			return err
+		}
+
+		pending, err := tx.CreateBucketIfNotExists(pendingBucket)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(deadLettersBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(keysBucket); err != nil {
+			return err
+		}
+
+		interrupted := []*Message{}
+
+		err = messages.ForEach(func(id, value []byte) error {
+
+			msg := &Message{}
+			if err := json.Unmarshal(value, msg); err != nil {
+				return err
+			}
+
+			if msg.Status == StatusSending || msg.Status == StatusRendering {
+				interrupted = append(interrupted, msg)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Buckets must not be changed while they are iterated.
+		for _, msg := range interrupted {
+
+			if msg.Status == StatusRendering {
+				if err := messages.Delete([]byte(msg.ID)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			msg.Status = StatusQueued
+			if err := putMessage(tx, msg); err != nil {
+				return err
+			}
+
+			if err := pending.Put(pendingKey(msg), nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Reserve stores msg in the rendering state and assigns its ID, so that the
+// message can be tracked before its content exists. Subscribers learn of the
+// message once it is queued.
+func (q *Queue) Reserve(msg *Message) error {
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	msg.ID = id
+	msg.Status = StatusRendering
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		return putMessage(tx, msg)
+	})
+}
+
+// Discard deletes a reserved message that could not be rendered. The
+// request fails as a whole, so the message is not kept.
+func (q *Queue) Discard(msg *Message) error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(messagesBucket).Delete([]byte(msg.ID))
+	})
+}
+
+// Enqueue stores msg and schedules it for delivery at its DueAt time, or
+// immediately when it has none. Messages that were not reserved are
+// assigned a new ID.
+func (q *Queue) Enqueue(msg *Message) error {
+
+	now := time.Now()
+
+	if msg.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		msg.ID = id
+		msg.CreatedAt = now
+	}
+
+	msg.Status = StatusQueued
+	msg.UpdatedAt = now
+	if msg.DueAt.Before(now) {
+		msg.DueAt = now
+	}
+	msg.QueuedAt = msg.DueAt
+
+	err := q.save(msg, func(tx *bbolt.Tx) error {
+		if err := putMessage(tx, msg); err != nil {
+			return err
+		}
+		return tx.Bucket(pendingBucket).Put(pendingKey(msg), nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	q.notify()
+
+	return nil
+}
+
+func (q *Queue) Get(id string) (*Message, error) {
+
+	msg := &Message{}
+
+	err := q.db.View(func(tx *bbolt.Tx) error {
+		return getMessage(tx, id, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Remember stores value under an idempotency key until expiresAt.
+func (q *Queue) Remember(key string, value []byte, expiresAt time.Time) error {
+
+	var entry bytes.Buffer
+	binary.Write(&entry, binary.BigEndian, uint64(expiresAt.UnixNano()))
+	entry.Write(value)
+
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(keysBucket).Put([]byte(key), entry.Bytes())
+	})
+}
+
+// Recall returns the value stored under an idempotency key, or nil when the
+// key is unknown or expired.
+func (q *Queue) Recall(key string) ([]byte, error) {
+
+	var value []byte
+
+	err := q.db.View(func(tx *bbolt.Tx) error {
+
+		entry := tx.Bucket(keysBucket).Get([]byte(key))
+		if entry == nil || keyExpired(entry, time.Now()) {
+			return nil
+		}
+
+		value = bytes.Clone(entry[8:])
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// purgeKeys deletes the expired idempotency keys.
+func (q *Queue) purgeKeys() error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+
+		now := time.Now()
+		keys := tx.Bucket(keysBucket)
+		expired := [][]byte{}
+
+		// Deleting with the cursor while iterating skips the next key.
+		err := keys.ForEach(func(key, entry []byte) error {
+			if keyExpired(entry, now) {
+				expired = append(expired, bytes.Clone(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := keys.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// pruneMessages deletes the sent, failed and cancelled messages that have
+// not changed for the retention period, including their dead letters.
+func (q *Queue) pruneMessages() error {
+
+	if q.retention <= 0 {
+		return nil
+	}
+
+	return q.db.Update(func(tx *bbolt.Tx) error {
+
+		cutoff := time.Now().Add(-q.retention)
+		messages := tx.Bucket(messagesBucket)
+		expired := [][]byte{}
+
+		err := messages.ForEach(func(id, value []byte) error {
+
+			msg := &Message{}
+			if err := json.Unmarshal(value, msg); err != nil {
+				return err
+			}
+
+			switch msg.Status {
+			case StatusSent, StatusFailed, StatusCancelled:
+				if msg.UpdatedAt.Before(cutoff) {
+					expired = append(expired, bytes.Clone(id))
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		deadLetters := tx.Bucket(deadLettersBucket)
+
+		for _, id := range expired {
+			if err := messages.Delete(id); err != nil {
+				return err
+			}
+			if err := deadLetters.Delete(id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func keyExpired(entry []byte, now time.Time) bool {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(entry[:8]))).Before(now)
+}
+
+// Start launches the delivery workers.
+func (q *Queue) Start(workers int, deliver DeliverFunc) {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(deliver)
+	}
+
+	q.wg.Add(1)
+	go q.purge()
+}
+
+func (q *Queue) purge() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.purgeKeys(); err != nil {
+				log.Println("queue purge error: ", err.Error())
+			}
+			if err := q.pruneMessages(); err != nil {
+				log.Println("queue purge error: ", err.Error())
+			}
+		}
+	}
+}
+
+func (q *Queue) work(deliver DeliverFunc) {
+	defer q.wg.Done()
+
+	for {
+		msg, wait, err := q.claim(time.Now())
+		if err != nil {
+			log.Println("queue claim error: ", err.Error())
+			wait = idleInterval
+		}
+
+		if msg == nil {
+			select {
+			case <-q.ctx.Done():
+				return
+			case <-q.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		err = deliver(msg)
+
+		if err := q.complete(msg, err); err != nil {
+			log.Println("queue update error: ", err.Error())
+		}
+	}
+}
+
+// claim takes the earliest due message out of the pending index and marks
+// it as sending. When no message is due it returns how long to wait for the
+// next one.
+func (q *Queue) claim(now time.Time) (*Message, time.Duration, error) {
+
+	var msg *Message
+	wait := idleInterval
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+
+		cursor := tx.Bucket(pendingBucket).Cursor()
+
+		key, _ := cursor.First()
+		if key == nil {
+			return nil
+		}
+
+		due := time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+		if due.After(now) {
+			wait = min(due.Sub(now), idleInterval)
+			return nil
+		}
+
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+
+		value := tx.Bucket(messagesBucket).Get(key[8:])
+		if value == nil {
+			return nil
+		}
+
+		msg = &Message{}
+		if err := json.Unmarshal(value, msg); err != nil {
+			return err
+		}
+
+		msg.Status = StatusSending
+		msg.UpdatedAt = now
+
+		return putMessage(tx, msg)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if msg == nil {
+		return nil, wait, nil
+	}
+
+	// Claims are not published, as the message may be deferred without an
+	// attempt.
+
+	// Another message may be due as well.
+	q.notify()
+
+	return msg, 0, nil
+}
+
+// complete records the outcome of a delivery attempt. Transient failures
+// are rescheduled according to the retry policy; permanent failures and
+// messages that ran out of retries become dead letters.
+func (q *Queue) complete(msg *Message, err error) error {
+
+	now := time.Now()
+	msg.UpdatedAt = now
+
+	if err == nil {
+		msg.Status = StatusSent
+		msg.Error = ""
+		msg.Attempts = append(msg.Attempts, Attempt{At: now, Backend: msg.Backend})
+
+		return q.save(msg, func(tx *bbolt.Tx) error {
+			return putMessage(tx, msg)
+		})
+	}
+
+	var deferErr *DeferError
+	if errors.As(err, &deferErr) {
+
+		if q.retry.MaxAge > 0 && now.Sub(msg.QueuedAt) >= q.retry.MaxAge {
+			err = &DeliveryError{
+				Permanent: true,
+				Err:       fmt.Errorf("%s for longer than %s", deferErr.Reason, q.retry.MaxAge),
+			}
+		} else {
+			wait := max(deferErr.Until.Sub(now), 0)
+
+			msg.Status = StatusDeferred
+			msg.Error = deferErr.Reason
+			msg.DueAt = deferErr.Until.Add(time.Duration(mathrand.Int64N(int64(wait) + 1)))
+
+			return q.db.Update(func(tx *bbolt.Tx) error {
+				if err := putMessage(tx, msg); err != nil {
+					return err
+				}
+				return tx.Bucket(pendingBucket).Put(pendingKey(msg), nil)
+			})
+		}
+	}
+
+	deliveryErr := &DeliveryError{Err: err}
+	errors.As(err, &deliveryErr)
+
+	msg.Error = err.Error()
+	msg.Retries++
+	msg.Attempts = append(msg.Attempts, Attempt{
+		At:      now,
+		Backend: msg.Backend,
+		Code:    deliveryErr.Code,
+		Error:   err.Error(),
+	})
+
+	dead := deliveryErr.Permanent ||
+		(q.retry.MaxAttempts > 0 && msg.Retries >= q.retry.MaxAttempts) ||
+		(q.retry.MaxAge > 0 && now.Sub(msg.QueuedAt) >= q.retry.MaxAge)
+
+	if dead {
+		msg.Status = StatusFailed
+		log.Printf("message %s failed: %s\n", msg.ID, err.Error())
+	} else {
+		msg.Status = StatusDeferred
+		msg.DueAt = now.Add(q.retry.Backoff(msg.Retries))
+		log.Printf("message %s deferred until %s: %s\n", msg.ID, msg.DueAt.Format(time.RFC3339), err.Error())
+	}
+
+	return q.save(msg, func(tx *bbolt.Tx) error {
+
+		if err := putMessage(tx, msg); err != nil {
+			return err
+		}
+
+		if dead {
+			return tx.Bucket(deadLettersBucket).Put([]byte(msg.ID), nil)
+		}
+
+		return tx.Bucket(pendingBucket).Put(pendingKey(msg), nil)
+	})
+}
+
+// DeadLetters lists the messages that permanently failed.
+func (q *Queue) DeadLetters() ([]*Message, error) {
+
+	messages := []*Message{}
+
+	err := q.db.View(func(tx *bbolt.Tx) error {
+
+		stored := tx.Bucket(messagesBucket)
+
+		return tx.Bucket(deadLettersBucket).ForEach(func(id, _ []byte) error {
+
+			value := stored.Get(id)
+			if value == nil {
+				return nil
+			}
+
+			msg := &Message{}
+			if err := json.Unmarshal(value, msg); err != nil {
+				return err
+			}
+
+			messages = append(messages, msg)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetDeadLetter returns a dead letter, or ErrNotFound when the message is
+// not a dead letter.
+func (q *Queue) GetDeadLetter(id string) (*Message, error) {
+
+	msg := &Message{}
+
+	err := q.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(deadLettersBucket).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return getMessage(tx, id, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Requeue moves a dead letter back into the queue with a fresh retry budget.
+func (q *Queue) Requeue(id string) (*Message, error) {
+
+	msg := &Message{}
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+
+		deadLetters := tx.Bucket(deadLettersBucket)
+		if deadLetters.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+
+		if err := getMessage(tx, id, msg); err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		msg.Status = StatusQueued
+		msg.Error = ""
+		msg.UpdatedAt = now
+		msg.QueuedAt = now
+		msg.DueAt = now
+		msg.Retries = 0
+
+		if err := putMessage(tx, msg); err != nil {
+			return err
+		}
+
+		if err := deadLetters.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(pendingBucket).Put(pendingKey(msg), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q.publish(msg)
+	q.notify()
+
+	return msg, nil
+}
+
+// Cancel withdraws a message that is waiting for delivery.
+func (q *Queue) Cancel(id string) (*Message, error) {
+	return q.updatePending(id, func(msg *Message, now time.Time) {
+		msg.Status = StatusCancelled
+	})
+}
+
+// Reschedule moves the delivery of a waiting message to the given time.
+// Messages that were not attempted since they were queued count their
+// retries and age from the new time. Others keep them, so that
+// rescheduling cannot keep a failing message alive.
+func (q *Queue) Reschedule(id string, at time.Time) (*Message, error) {
+
+	if err := CheckSchedule(at); err != nil {
+		return nil, err
+	}
+
+	return q.updatePending(id, func(msg *Message, now time.Time) {
+		if at.Before(now) {
+			at = now
+		}
+		msg.DueAt = at
+		if msg.Status == StatusQueued && msg.Retries == 0 {
+			msg.QueuedAt = at
+		}
+	})
+}
+
+// updatePending takes a waiting message out of the pending index, applies
+// update and indexes it again unless it was cancelled.
+func (q *Queue) updatePending(id string, update func(msg *Message, now time.Time)) (*Message, error) {
+
+	msg := &Message{}
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+
+		if err := getMessage(tx, id, msg); err != nil {
+			return err
+		}
+
+		if msg.Status != StatusQueued && msg.Status != StatusDeferred {
+			return ErrNotPending
+		}
+
+		pending := tx.Bucket(pendingBucket)
+		if err := pending.Delete(pendingKey(msg)); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		update(msg, now)
+		msg.UpdatedAt = now
+
+		if err := putMessage(tx, msg); err != nil {
+			return err
+		}
+
+		if msg.Status == StatusCancelled {
+			return nil
+		}
+
+		return pending.Put(pendingKey(msg), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q.publish(msg)
+	q.notify()
+
+	return msg, nil
+}
+
+// save runs update in a transaction and publishes the new state of msg once
+// it is committed.
+func (q *Queue) save(msg *Message, update func(tx *bbolt.Tx) error) error {
+
+	if err := q.db.Update(update); err != nil {
+		return err
+	}
+
+	q.publish(msg)
+
+	return nil
+}
+
+// Subscribe registers fn to be called with every status change of a
+// message. Subscribers are called synchronously and must not block. The
+// returned function removes the subscription.
+func (q *Queue) Subscribe(fn func(event *Event)) func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := q.nextID
+	q.nextID++
+	q.subscribers[id] = fn
+
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		delete(q.subscribers, id)
+	}
+}
+
+func (q *Queue) publish(msg *Message) {
+
+	event := &Event{
+		MessageID:     msg.ID,
+		Client:        msg.Client,
+		Status:        msg.Status,
+		TemplateGroup: msg.TemplateGroup,
+		To:            msg.visibleTo(),
+		Error:         msg.Error,
+		At:            msg.UpdatedAt,
+	}
+
+	if len(msg.Attempts) > 0 && (msg.Status == StatusSent || msg.Status == StatusDeferred || msg.Status == StatusFailed) {
+		event.Code = msg.Attempts[len(msg.Attempts)-1].Code
+	}
+
+	q.mu.Lock()
+	subscribers := make([]func(event *Event), 0, len(q.subscribers))
+	for _, fn := range q.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	q.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// visibleTo returns the recipients of msg that are not Bcc recipients.
+func (msg *Message) visibleTo() []string {
+
+	if len(msg.Bcc) == 0 {
+		return msg.To
+	}
+
+	visible := make([]string, 0, len(msg.To))
+	for _, address := range msg.To {
+		if !slices.Contains(msg.Bcc, address) {
+			visible = append(visible, address)
+		}
+	}
+
+	return visible
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the workers, waits for running deliveries and closes the
+// database.
+func (q *Queue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+	return q.db.Close()
+}
+
+func getMessage(tx *bbolt.Tx, id string, msg *Message) error {
+	value := tx.Bucket(messagesBucket).Get([]byte(id))
+	if value == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(value, msg)
+}
+
+func putMessage(tx *bbolt.Tx, msg *Message) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(messagesBucket).Put([]byte(msg.ID), value)
+}
+
+// pendingKey orders the pending index by due time.
+func pendingKey(msg *Message) []byte {
+	var key bytes.Buffer
+	binary.Write(&key, binary.BigEndian, uint64(msg.DueAt.UnixNano()))
+	key.WriteString(msg.ID)
+	return key.Bytes()
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
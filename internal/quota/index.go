@@ -0,0 +1,113 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Hourly and Daily cap the messages each client may send per clock hour
+	// and per day (UTC). Zero limits are not enforced.
+	Hourly int
+	Daily  int
+}
+
+// ExceededError is returned when a client used up its quota. RetryAfter is
+// how long until the quota resets.
+type ExceededError struct {
+	Client     string
+	RetryAfter time.Duration
+}
+
+func (err *ExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded, retry after %s", err.RetryAfter.Round(time.Second))
+}
+
+// usage counts the messages of a client in the current hour and day.
+type usage struct {
+	hour   time.Time
+	hourly int
+	day    time.Time
+	daily  int
+}
+
+// Quota tracks the messages sent by each client. The counters are kept in
+// memory and start over when the process restarts.
+type Quota struct {
+	cfg     *Config
+	mu      sync.Mutex
+	clients map[string]*usage
+	pruned  time.Time
+}
+
+func New(cfg *Config) *Quota {
+	return &Quota{
+		cfg:     cfg,
+		clients: make(map[string]*usage),
+		pruned:  time.Now(),
+	}
+}
+
+// Take counts n messages of client against its quota. Nothing is counted
+// when the messages would exceed the quota.
+func (q *Quota) Take(client string, n int) error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if now.Sub(q.pruned) >= time.Hour {
+		q.prune(day)
+	}
+
+	u, exists := q.clients[client]
+	if !exists {
+		u = &usage{}
+		q.clients[client] = u
+	}
+
+	if !u.hour.Equal(hour) {
+		u.hour = hour
+		u.hourly = 0
+	}
+
+	if !u.day.Equal(day) {
+		u.day = day
+		u.daily = 0
+	}
+
+	if q.cfg.Daily > 0 && u.daily+n > q.cfg.Daily {
+		return &ExceededError{
+			Client:     client,
+			RetryAfter: day.AddDate(0, 0, 1).Sub(now),
+		}
+	}
+
+	if q.cfg.Hourly > 0 && u.hourly+n > q.cfg.Hourly {
+		return &ExceededError{
+			Client:     client,
+			RetryAfter: hour.Add(time.Hour).Sub(now),
+		}
+	}
+
+	u.hourly += n
+	u.daily += n
+
+	return nil
+}
+
+// prune drops the clients that sent nothing today.
+func (q *Quota) prune(day time.Time) {
+
+	q.pruned = time.Now()
+
+	for client, u := range q.clients {
+		if u.day.Before(day) {
+			delete(q.clients, client)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// busyDelay is how long a message waits when its recipient domains have no
+// free connection slot.
+const busyDelay = time.Second
+
+// pruneInterval is how often idle domains are dropped from the limiter.
+const pruneInterval = time.Minute
+
+// Limit allows Count messages per Per.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseLimit parses limits such as "10/s", "500/m", "10000/d" or "100/30m".
+func ParseLimit(value string) (Limit, error) {
+
+	count, per, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", value)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", value)
+	}
+
+	var d time.Duration
+
+	switch per {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	case "d":
+		d = 24 * time.Hour
+	default:
+		d, err = time.ParseDuration(per)
+		if err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q", value)
+		}
+	}
+
+	return Limit{Count: n, Per: d}, nil
+}
+
+type Config struct {
+	// Account limits the messages sent through each SMTP backend, as each
+	// backend is an account of its own.
+	Account []Limit
+	// Domain limits the messages sent to each recipient domain.
+	Domain []Limit
+	// DomainConcurrency caps the deliveries in progress per recipient
+	// domain. Zero means no cap.
+	DomainConcurrency int
+}
+
+// bucket is a token bucket holding up to Count tokens and refilling Count
+// tokens every Per.
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{
+		limit:   limit,
+		tokens:  float64(limit.Count),
+		updated: now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	rate := float64(b.limit.Count) / float64(b.limit.Per)
+	b.tokens = min(float64(b.limit.Count), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+}
+
+// wait returns how long until the bucket holds a token.
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	rate := float64(b.limit.Count) / float64(b.limit.Per)
+	return time.Duration((1 - b.tokens) / rate)
+}
+
+type domain struct {
+	buckets []*bucket
+	active  int
+}
+
+// Limiter enforces the account and per-domain limits. Its state is kept in
+// memory and starts over when the process restarts.
+type Limiter struct {
+	cfg      *Config
+	mu       sync.Mutex
+	accounts map[string][]*bucket
+	domains  map[string]*domain
+	pruned   time.Time
+}
+
+func New(cfg *Config) *Limiter {
+	return &Limiter{
+		cfg:      cfg,
+		accounts: make(map[string][]*bucket),
+		domains:  make(map[string]*domain),
+		pruned:   time.Now(),
+	}
+}
+
+// Accounts returns the backends among names whose account limits allow
+// another message. When none does, the time at which to try again is
+// returned. The backend that sends the message is charged with Charge.
+func (l *Limiter) Accounts(names []string) ([]string, time.Time) {
+
+	if len(l.cfg.Account) == 0 {
+		return names, time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	available := []string{}
+	var wait time.Duration
+
+	for _, name := range names {
+
+		var backendWait time.Duration
+		for _, b := range l.account(name, now) {
+			b.refill(now)
+			backendWait = max(backendWait, b.wait())
+		}
+
+		if backendWait == 0 {
+			available = append(available, name)
+		} else if wait == 0 || backendWait < wait {
+			wait = backendWait
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, now.Add(wait)
+	}
+
+	return available, time.Time{}
+}
+
+// Charge takes a message from the account limits of a backend.
+func (l *Limiter) Charge(name string) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for _, b := range l.account(name, now) {
+		b.refill(now)
+		b.tokens--
+	}
+}
+
+// account returns the buckets of a backend.
+func (l *Limiter) account(name string, now time.Time) []*bucket {
+
+	buckets, exists := l.accounts[name]
+	if !exists {
+		for _, limit := range l.cfg.Account {
+			buckets = append(buckets, newBucket(limit, now))
+		}
+		l.accounts[name] = buckets
+	}
+
+	return buckets
+}
+
+// Acquire reserves the sending of one message to the given recipients
+// under the domain limits. When a limit is reached nothing is reserved and
+// the time at which to try again is returned. Otherwise the returned
+// release function must be called once the delivery attempt is over.
+func (l *Limiter) Acquire(to []string) (func(), time.Time, bool) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+
+	var wait time.Duration
+
+	domains := l.recipientDomains(to, now)
+
+	for _, d := range domains {
+
+		if l.cfg.DomainConcurrency > 0 && d.active >= l.cfg.DomainConcurrency {
+			wait = max(wait, busyDelay)
+		}
+
+		for _, b := range d.buckets {
+			b.refill(now)
+			wait = max(wait, b.wait())
+		}
+	}
+
+	if wait > 0 {
+		return nil, now.Add(wait), false
+	}
+
+	for _, d := range domains {
+		d.active++
+		for _, b := range d.buckets {
+			b.tokens--
+		}
+	}
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		for _, d := range domains {
+			d.active--
+		}
+	}
+
+	return release, time.Time{}, true
+}
+
+// recipientDomains returns the state of every distinct domain of to.
+func (l *Limiter) recipientDomains(to []string, now time.Time) []*domain {
+
+	if len(l.cfg.Domain) == 0 && l.cfg.DomainConcurrency == 0 {
+		return nil
+	}
+
+	seen := make(map[string]struct{})
+	domains := []*domain{}
+
+	for _, address := range to {
+
+		at := strings.LastIndex(address, "@")
+		if at < 0 {
+			continue
+		}
+
+		name := strings.ToLower(address[at+1:])
+		if _, exists := seen[name]; exists {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		d, exists := l.domains[name]
+		if !exists {
+			d = &domain{}
+			for _, limit := range l.cfg.Domain {
+				d.buckets = append(d.buckets, newBucket(limit, now))
+			}
+			l.domains[name] = d
+		}
+
+		domains = append(domains, d)
+	}
+
+	return domains
+}
+
+// prune drops the domains without deliveries in progress whose buckets are
+// full again, as they are equal to a newly seen domain.
+func (l *Limiter) prune(now time.Time) {
+
+	l.pruned = now
+
+	for name, d := range l.domains {
+
+		if d.active > 0 {
+			continue
+		}
+
+		idle := true
+		for _, b := range d.buckets {
+			b.refill(now)
+			if b.tokens < float64(b.limit.Count) {
+				idle = false
+				break
+			}
+		}
+
+		if idle {
+			delete(l.domains, name)
+		}
+	}
+}
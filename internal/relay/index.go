@@ -0,0 +1,226 @@
+package relay
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/smtp"
+	"github.com/lucap9056/mail-template-sender/internal/transport"
+)
+
+// Backend is a named transport, usually an SMTP server. Backends with a
+// lower Priority are tried first; backends of the same priority share the
+// load by Weight.
+type Backend struct {
+	Name      string
+	Priority  int
+	Weight    int
+	Transport transport.Transport
+}
+
+type backend struct {
+	*Backend
+	// failedAt is the time of the last failure that caused a failover.
+	failedAt time.Time
+}
+
+// Relay sends messages through a set of SMTP backends. A backend that
+// cannot be reached or answers with a 4xx reply is skipped for the next
+// one in order, and is tried after the healthy backends until Cooldown
+// has passed.
+type Relay struct {
+	mu       sync.Mutex
+	backends []*backend
+	cooldown time.Duration
+}
+
+func New(backends []*Backend, cooldown time.Duration) (*Relay, error) {
+
+	if len(backends) == 0 {
+		return nil, errors.New("no smtp backends configured")
+	}
+
+	r := &Relay{
+		cooldown: cooldown,
+	}
+
+	names := make(map[string]struct{})
+
+	for _, b := range backends {
+
+		if _, exists := names[b.Name]; exists {
+			return nil, errors.New("duplicate smtp backend " + b.Name)
+		}
+		names[b.Name] = struct{}{}
+
+		if b.Weight <= 0 {
+			b.Weight = 1
+		}
+
+		r.backends = append(r.backends, &backend{Backend: b})
+	}
+
+	sort.SliceStable(r.backends, func(i, j int) bool {
+		return r.backends[i].Priority < r.backends[j].Priority
+	})
+
+	return r, nil
+}
+
+// Username returns the account of the backend with the highest priority
+// that logs in to a server, or an empty string when there is none.
+func (r *Relay) Username() string {
+	for _, b := range r.backends {
+		if account, ok := b.Transport.(interface{ Username() string }); ok {
+			return account.Username()
+		}
+	}
+	return ""
+}
+
+// Names returns the names of the backends in order of priority.
+func (r *Relay) Names() []string {
+	names := make([]string, 0, len(r.backends))
+	for _, b := range r.backends {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+// Has reports whether a backend is named name.
+func (r *Relay) Has(name string) bool {
+	for _, b := range r.backends {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Send delivers msg through the first backend that accepts it and returns
+// the name of the backend that handled it last. Only the backends listed
+// in names are used, or all of them when names is empty. Permanent 5xx
+// replies and partial deliveries are returned without trying the other
+// backends, as they would reject the message as well or deliver it twice.
+func (r *Relay) Send(names []string, from string, to []string, msg []byte) (string, error) {
+
+	var name string
+	var err error
+
+	for _, b := range r.order(names) {
+
+		name = b.Name
+
+		err = b.Transport.Send(from, to, msg)
+		if err == nil {
+			return name, nil
+		}
+
+		var partial *smtp.PartialError
+		if errors.As(err, &partial) && len(partial.Accepted) > 0 {
+			return name, err
+		}
+
+		code := smtp.ReplyCode(err)
+		if code >= 500 {
+			return name, err
+		}
+
+		r.mu.Lock()
+		b.failedAt = time.Now()
+		r.mu.Unlock()
+
+		log.Printf("smtp backend %s failed: %s\n", name, err.Error())
+	}
+
+	if name == "" {
+		return "", errors.New("no smtp backend named " + strings.Join(names, ", "))
+	}
+
+	return name, err
+}
+
+// order returns the backends among names in the order to try them: by
+// priority, in a weighted random order within a priority, and with the
+// backends that failed recently moved to the end.
+func (r *Relay) order(names []string) []*backend {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	backends := r.backends
+	if len(names) > 0 {
+		backends = []*backend{}
+		for _, b := range r.backends {
+			if slices.Contains(names, b.Name) {
+				backends = append(backends, b)
+			}
+		}
+	}
+
+	healthy := []*backend{}
+	failed := []*backend{}
+
+	for start := 0; start < len(backends); {
+
+		end := start
+		for end < len(backends) && backends[end].Priority == backends[start].Priority {
+			end++
+		}
+
+		for _, b := range shuffle(backends[start:end]) {
+			if !b.failedAt.IsZero() && now.Sub(b.failedAt) < r.cooldown {
+				failed = append(failed, b)
+			} else {
+				healthy = append(healthy, b)
+			}
+		}
+
+		start = end
+	}
+
+	return append(healthy, failed...)
+}
+
+// shuffle returns the backends in a random order where each position is
+// drawn in proportion to the remaining weights.
+func shuffle(backends []*backend) []*backend {
+
+	remaining := append([]*backend{}, backends...)
+	ordered := make([]*backend, 0, len(backends))
+
+	for len(remaining) > 0 {
+
+		total := 0
+		for _, b := range remaining {
+			total += b.Weight
+		}
+
+		n := rand.Intn(total)
+
+		i := 0
+		for n >= remaining[i].Weight {
+			n -= remaining[i].Weight
+			i++
+		}
+
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+
+	return ordered
+}
+
+func (r *Relay) Close() {
+	for _, b := range r.backends {
+		b.Transport.Close()
+	}
+}
@@ -0,0 +1,205 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Fields a rule can match.
+const (
+	FieldGroup    = "group"
+	FieldCategory = "category"
+	FieldDomain   = "domain"
+	FieldTag      = "tag"
+	// FieldDefault is the field of the rule written as "*=backend", which
+	// routes the recipients no other rule matches.
+	FieldDefault = "*"
+)
+
+// Rule sends the messages whose Field matches Value through Backends.
+// Domain values match the recipient domain exactly, or every subdomain
+// when they start with "*.".
+type Rule struct {
+	Field    string
+	Value    string
+	Backends []string
+}
+
+// ParseRules parses a comma separated list of field:value=backend rules,
+// such as "group:newsletter=marketing,domain:example.com=relay,*=primary".
+// Several backends of a rule are separated by "|", e.g.
+// "tag:alerts=primary|backup".
+func ParseRules(value string) ([]*Rule, error) {
+
+	rules := []*Rule{}
+
+	for _, entry := range strings.Split(value, ",") {
+
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		match, backends, found := strings.Cut(entry, "=")
+		field, value, hasValue := strings.Cut(match, ":")
+		if strings.TrimSpace(match) == FieldDefault {
+			field, value, hasValue = FieldDefault, FieldDefault, true
+		}
+		if !found || !hasValue || value == "" || backends == "" {
+			return nil, fmt.Errorf("invalid routing rule %q, expected field:value=backend", entry)
+		}
+
+		field = strings.ToLower(strings.TrimSpace(field))
+
+		switch field {
+		case FieldGroup, FieldCategory, FieldTag:
+		case FieldDefault:
+			if value != FieldDefault {
+				return nil, fmt.Errorf("invalid routing rule %q, expected *=backend", entry)
+			}
+		case FieldDomain:
+			value = strings.ToLower(value)
+		default:
+			return nil, fmt.Errorf("unknown field %q in routing rule %q", field, entry)
+		}
+
+		rule := &Rule{
+			Field: field,
+			Value: strings.TrimSpace(value),
+		}
+
+		for _, backend := range strings.Split(backends, "|") {
+			if backend = strings.TrimSpace(backend); backend != "" {
+				rule.Backends = append(rule.Backends, backend)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Message holds the attributes of a message the rules match against.
+type Message struct {
+	Group      string
+	Category   string
+	Tag        string
+	Recipients []string
+}
+
+// Route is a set of recipients of a message that go through the same
+// backends.
+type Route struct {
+	Backends   []string
+	Recipients []string
+}
+
+type Router struct {
+	rules    []*Rule
+	fallback *Rule
+}
+
+// New returns a router for rules between the given backends. Recipients
+// that no rule matches go through the backends of the "*" rule, or when
+// there is none, through the backends that no rule names.
+func New(rules []*Rule, backends []string) (*Router, error) {
+
+	r := &Router{}
+
+	for _, rule := range rules {
+
+		if rule.Field != FieldDefault {
+			r.rules = append(r.rules, rule)
+			continue
+		}
+
+		if r.fallback != nil {
+			return nil, errors.New("more than one default routing rule")
+		}
+		r.fallback = rule
+	}
+
+	if r.fallback == nil {
+
+		r.fallback = &Rule{Field: FieldDefault, Value: FieldDefault}
+
+		for _, backend := range backends {
+			named := slices.ContainsFunc(rules, func(rule *Rule) bool {
+				return slices.Contains(rule.Backends, backend)
+			})
+			if !named {
+				r.fallback.Backends = append(r.fallback.Backends, backend)
+			}
+		}
+
+		if len(r.fallback.Backends) == 0 {
+			return nil, errors.New("every backend is named by a routing rule, add a default rule such as *=backend")
+		}
+	}
+
+	return r, nil
+}
+
+// Route splits the recipients of msg by the backends of the first rule that
+// matches each of them. Group, category and tag rules match all recipients
+// alike, domain rules the recipients in the domain.
+func (r *Router) Route(msg *Message) []*Route {
+
+	routes := []*Route{}
+	byBackends := make(map[string]*Route)
+
+	for _, recipient := range msg.Recipients {
+
+		rule := r.fallback
+		for _, candidate := range r.rules {
+			if candidate.matches(msg, recipient) {
+				rule = candidate
+				break
+			}
+		}
+
+		// Rules with the same backends share a route.
+		key := strings.Join(rule.Backends, "|")
+
+		route, exists := byBackends[key]
+		if !exists {
+			route = &Route{Backends: rule.Backends}
+			byBackends[key] = route
+			routes = append(routes, route)
+		}
+
+		route.Recipients = append(route.Recipients, recipient)
+	}
+
+	return routes
+}
+
+func (rule *Rule) matches(msg *Message, recipient string) bool {
+
+	switch rule.Field {
+	case FieldGroup:
+		return msg.Group == rule.Value
+	case FieldCategory:
+		return msg.Category == rule.Value
+	case FieldTag:
+		return msg.Tag != "" && msg.Tag == rule.Value
+	case FieldDomain:
+		return rule.matchesDomain(recipient)
+	}
+
+	return false
+}
+
+func (rule *Rule) matchesDomain(address string) bool {
+
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+
+	if suffix, wildcard := strings.CutPrefix(rule.Value, "*."); wildcard {
+		return strings.HasSuffix(domain, "."+suffix)
+	}
+
+	return domain == rule.Value
+}
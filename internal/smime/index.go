@@ -0,0 +1,443 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/mimepart"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidData               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidSHA256             = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidAttrContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAES256CBC          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	contextSpecific0      = cryptobyte_asn1.Tag(0).ContextSpecific()
+	contextSpecific0Build = cryptobyte_asn1.Tag(0).ContextSpecific().Constructed()
+)
+
+type Config struct {
+	// Certificate signs the messages when it is set. Its chain is included
+	// in the signatures.
+	Certificate *tls.Certificate
+	// RecipientsDirectory holds the PEM certificates of the recipients,
+	// named after their address, such as "alice@example.com.pem". Messages
+	// are encrypted when every recipient has a certificate.
+	RecipientsDirectory string
+}
+
+type SMIME struct {
+	cfg   *Config
+	chain []*x509.Certificate
+}
+
+func New(cfg *Config) (*SMIME, error) {
+
+	s := &SMIME{
+		cfg: cfg,
+	}
+
+	if cfg.Certificate != nil {
+
+		for _, der := range cfg.Certificate.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			s.chain = append(s.chain, cert)
+		}
+
+		if len(s.chain) == 0 {
+			return nil, errors.New("smime: certificate is empty")
+		}
+
+		switch cfg.Certificate.PrivateKey.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		default:
+			return nil, fmt.Errorf("smime: unsupported key type %T", cfg.Certificate.PrivateKey)
+		}
+	}
+
+	return s, nil
+}
+
+// Encrypts reports whether messages are encrypted to the recipients that
+// have a registered certificate.
+func (s *SMIME) Encrypts() bool {
+	return s.cfg.RecipientsDirectory != ""
+}
+
+// Seal signs msg when a certificate is configured, and encrypts it when all
+// recipients have a registered certificate. When only some of them have
+// one, msg is not encrypted and the recipients without a certificate are
+// returned.
+func (s *SMIME) Seal(msg []byte, recipients []string) ([]byte, []string, error) {
+
+	certs, missing, err := s.recipientCertificates(recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(missing) == len(recipients) {
+		missing = nil
+	}
+
+	if s.cfg.Certificate == nil && certs == nil {
+		return msg, missing, nil
+	}
+
+	header, entity, err := mimepart.Split(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.cfg.Certificate != nil {
+		entity, err = s.sign(entity)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if certs != nil {
+		entity, err = encrypt(entity, certs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var sealed bytes.Buffer
+	sealed.Write(header)
+	sealed.WriteString("MIME-Version: 1.0\n")
+	sealed.Write(entity)
+
+	return sealed.Bytes(), missing, nil
+}
+
+// recipientCertificates returns the certificates of all recipients, or nil
+// and the recipients without one when some have none.
+func (s *SMIME) recipientCertificates(recipients []string) ([]*x509.Certificate, []string, error) {
+
+	if s.cfg.RecipientsDirectory == "" || len(recipients) == 0 {
+		return nil, nil, nil
+	}
+
+	certs := make([]*x509.Certificate, 0, len(recipients))
+	missing := []string{}
+
+	for _, recipient := range recipients {
+
+		name := strings.ToLower(recipient) + ".pem"
+		if strings.ContainsAny(name, `/\`) {
+			missing = append(missing, recipient)
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.cfg.RecipientsDirectory, name))
+		if errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, recipient)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, nil, fmt.Errorf("smime: no certificate in %s", name)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("smime: certificate %s: %v", name, err)
+		}
+
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, nil, fmt.Errorf("smime: certificate %s has no RSA key", name)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(missing) > 0 {
+		return nil, missing, nil
+	}
+
+	return certs, nil, nil
+}
+
+// sign wraps entity in a multipart/signed entity with a detached signature.
+func (s *SMIME) sign(entity []byte) ([]byte, error) {
+
+	signature, err := s.signedData(mimepart.CRLF(entity))
+	if err != nil {
+		return nil, err
+	}
+
+	boundary, err := mimepart.Boundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+
+	fmt.Fprintf(&signed, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"%s\"\n\n", boundary)
+	signed.WriteString("This is an S/MIME signed message.\n\n")
+	fmt.Fprintf(&signed, "--%s\n", boundary)
+	signed.Write(entity)
+	fmt.Fprintf(&signed, "\n--%s\n", boundary)
+	signed.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\n")
+	signed.WriteString("Content-Transfer-Encoding: base64\n")
+	signed.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\n\n")
+	signed.Write(mimepart.Base64(signature))
+	fmt.Fprintf(&signed, "--%s--", boundary)
+
+	return signed.Bytes(), nil
+}
+
+// signedData returns the DER encoded CMS SignedData of content, without the
+// content itself (RFC 5652 section 5).
+func (s *SMIME) signedData(content []byte) ([]byte, error) {
+
+	signer := s.chain[0]
+	key := s.cfg.Certificate.PrivateKey.(crypto.Signer)
+
+	digest := sha256.Sum256(content)
+
+	attributes, err := signedAttributes(digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	attributesDigest := sha256.Sum256(attributes)
+
+	signature, err := key.Sign(rand.Reader, attributesDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %v", err)
+	}
+
+	signatureAlgorithm := oidRSAEncryption
+	if _, isECDSA := key.(*ecdsa.PrivateKey); isECDSA {
+		signatureAlgorithm = oidECDSAWithSHA256
+	}
+
+	var b cryptobyte.Builder
+
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidSignedData)
+		b.AddASN1(contextSpecific0Build, func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(1)
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {
+					addAlgorithm(b, oidSHA256, false)
+				})
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier(oidData)
+				})
+				b.AddASN1(contextSpecific0Build, func(b *cryptobyte.Builder) {
+					for _, cert := range s.chain {
+						b.AddBytes(cert.Raw)
+					}
+				})
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1Int64(1)
+						addIssuerAndSerial(b, signer)
+						addAlgorithm(b, oidSHA256, false)
+						// The signed attributes are signed as a SET and
+						// stored with an implicit [0] tag.
+						b.AddASN1(contextSpecific0Build, func(b *cryptobyte.Builder) {
+							b.AddBytes(attributes[setHeaderLength(attributes):])
+						})
+						addAlgorithm(b, signatureAlgorithm, signatureAlgorithm.Equal(oidRSAEncryption))
+						b.AddASN1OctetString(signature)
+					})
+				})
+			})
+		})
+	})
+
+	return b.Bytes()
+}
+
+// signedAttributes returns the DER encoded SET of the content type, message
+// digest and signing time attributes.
+func signedAttributes(digest []byte) ([]byte, error) {
+
+	signingTime, err := asn1.Marshal(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := [][]byte{}
+
+	for _, attribute := range []struct {
+		oid   asn1.ObjectIdentifier
+		value func(b *cryptobyte.Builder)
+	}{
+		{oidAttrContentType, func(b *cryptobyte.Builder) { b.AddASN1ObjectIdentifier(oidData) }},
+		{oidAttrMessageDigest, func(b *cryptobyte.Builder) { b.AddASN1OctetString(digest) }},
+		{oidAttrSigningTime, func(b *cryptobyte.Builder) { b.AddBytes(signingTime) }},
+	} {
+		var b cryptobyte.Builder
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(attribute.oid)
+			b.AddASN1(cryptobyte_asn1.SET, attribute.value)
+		})
+
+		encoded, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+
+	// DER orders the elements of a SET by their encoding.
+	sort.Slice(attributes, func(i, j int) bool {
+		return bytes.Compare(attributes[i], attributes[j]) < 0
+	})
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {
+		for _, attribute := range attributes {
+			b.AddBytes(attribute)
+		}
+	})
+
+	return b.Bytes()
+}
+
+// encrypt wraps entity in an application/pkcs7-mime entity encrypted for
+// certs with AES-256-CBC.
+func encrypt(entity []byte, certs []*x509.Certificate) ([]byte, error) {
+
+	envelope, err := envelopedData(mimepart.CRLF(entity), certs)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted bytes.Buffer
+
+	encrypted.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\n")
+	encrypted.WriteString("Content-Transfer-Encoding: base64\n")
+	encrypted.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\n\n")
+	encrypted.Write(mimepart.Base64(envelope))
+
+	return encrypted.Bytes(), nil
+}
+
+// envelopedData returns the DER encoded CMS EnvelopedData of content (RFC
+// 5652 section 6), with the content key transported to each certificate
+// with RSA.
+func envelopedData(content []byte, certs []*x509.Certificate) ([]byte, error) {
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	ciphertext := append(bytes.Clone(content), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	encryptedKeys := make([][]byte, 0, len(certs))
+	for _, cert := range certs {
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, cert.PublicKey.(*rsa.PublicKey), key)
+		if err != nil {
+			return nil, fmt.Errorf("smime: %v", err)
+		}
+		encryptedKeys = append(encryptedKeys, encryptedKey)
+	}
+
+	var b cryptobyte.Builder
+
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidEnvelopedData)
+		b.AddASN1(contextSpecific0Build, func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(0)
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {
+					for i, cert := range certs {
+						b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1Int64(0)
+							addIssuerAndSerial(b, cert)
+							addAlgorithm(b, oidRSAEncryption, true)
+							b.AddASN1OctetString(encryptedKeys[i])
+						})
+					}
+				})
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier(oidData)
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1ObjectIdentifier(oidAES256CBC)
+						b.AddASN1OctetString(iv)
+					})
+					b.AddASN1(contextSpecific0, func(b *cryptobyte.Builder) {
+						b.AddBytes(ciphertext)
+					})
+				})
+			})
+		})
+	})
+
+	return b.Bytes()
+}
+
+func addAlgorithm(b *cryptobyte.Builder, oid asn1.ObjectIdentifier, null bool) {
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oid)
+		if null {
+			b.AddASN1NULL()
+		}
+	})
+}
+
+func addIssuerAndSerial(b *cryptobyte.Builder, cert *x509.Certificate) {
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddBytes(cert.RawIssuer)
+		b.AddASN1BigInt(cert.SerialNumber)
+	})
+}
+
+// setHeaderLength returns the length of the tag and length octets of a DER
+// element.
+func setHeaderLength(der []byte) int {
+	if der[1] < 0x80 {
+		return 2
+	}
+	return 2 + int(der[1]&0x7f)
+}
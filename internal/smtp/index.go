@@ -2,96 +2,251 @@ package smtp
 
 import (
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
 )
 
+// RecipientError is returned when the server rejects a recipient.
+type RecipientError struct {
+	Address string
+	Err     error
+}
+
+func (err *RecipientError) Error() string {
+	return err.Address + ": " + err.Err.Error()
+}
+
+func (err *RecipientError) Unwrap() error {
+	return err.Err
+}
+
+// PartialError is returned when a server accepted a message for some
+// recipients and rejected it for others, or rejected every recipient.
+// Accepted lists the recipients that received the message.
+type PartialError struct {
+	Accepted []string
+	Rejected []*RecipientError
+}
+
+func (err *PartialError) Error() string {
+	rejected := make([]string, 0, len(err.Rejected))
+	for _, rcptErr := range err.Rejected {
+		rejected = append(rejected, rcptErr.Error())
+	}
+	return fmt.Sprintf("%d of %d recipients rejected: %s", len(err.Rejected), len(err.Rejected)+len(err.Accepted), strings.Join(rejected, "; "))
+}
+
+func (err *PartialError) Unwrap() []error {
+	errs := make([]error, 0, len(err.Rejected))
+	for _, rcptErr := range err.Rejected {
+		errs = append(errs, rcptErr)
+	}
+	return errs
+}
+
 type SMTPConfig struct {
 	Username string
 	Password string
 	Address  string
 }
 
+// SMTP keeps one authenticated connection to the server. Sends are
+// serialized, and a connection that failed is replaced on the next send.
 type SMTP struct {
 	cfg    *SMTPConfig
+	host   string
+	mu     sync.Mutex
 	conn   *tls.Conn
 	client *smtp.Client
 }
 
 func New(cfg *SMTPConfig) (*SMTP, error) {
 
+	s, err := NewLazy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewLazy returns a client that connects on its first send, so that a
+// server that is down at startup does not keep the service from starting.
+func NewLazy(cfg *SMTPConfig) (*SMTP, error) {
+
 	host, _, err := net.SplitHostPort(cfg.Address)
 	if err != nil {
 		return nil, err
 	}
 
+	return &SMTP{
+		cfg:  cfg,
+		host: host,
+	}, nil
+}
+
+func (s *SMTP) connect() error {
+
 	tlsConfig := &tls.Config{
-		ServerName: host,
+		ServerName: s.host,
 	}
 
-	conn, err := tls.Dial("tcp", cfg.Address, tlsConfig)
+	conn, err := tls.Dial("tcp", s.cfg.Address, tlsConfig)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	client, err := smtp.NewClient(conn, host)
+	client, err := smtp.NewClient(conn, s.host)
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return err
 	}
 
 	err = client.Noop()
 	if err != nil {
-		return nil, err
+		client.Close()
+		return err
 	}
 
-	auth := smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.host)
 	err = client.Auth(auth)
 	if err != nil {
-		return nil, err
+		client.Close()
+		return err
 	}
 
-	return &SMTP{cfg, conn, client}, nil
+	s.conn = conn
+	s.client = client
+
+	return nil
 }
 
 func (s *SMTP) Username() string {
 	return s.cfg.Username
 }
 
-func (s *SMTP) Send(to []string, msg []byte) error {
+// Send delivers msg to the given recipients. The envelope sender defaults to
+// the account username when from is empty.
+func (s *SMTP) Send(from string, to []string, msg []byte) error {
 
-	err := s.client.Reset()
-	if err != nil {
-		return err
+	if from == "" {
+		from = s.cfg.Username
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The server may have dropped an idle connection since the last send.
+	if s.client != nil && s.client.Reset() != nil {
+		s.disconnect()
+	}
+
+	if s.client == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
 	}
 
-	err = s.client.Mail(s.cfg.Username)
+	err := s.send(from, to, msg)
+
+	// Server replies leave the connection usable; anything else means the
+	// connection is broken and has to be dialed again.
+	var protoErr *textproto.Error
+	if err != nil && !errors.As(err, &protoErr) {
+		s.disconnect()
+	}
+
+	return err
+}
+
+func (s *SMTP) send(from string, to []string, msg []byte) error {
+
+	err := s.client.Mail(from)
 	if err != nil {
 		return err
 	}
 
+	accepted := []string{}
+	rejected := []*RecipientError{}
+
+	// A rejected recipient does not stop the message from going to the
+	// others.
 	for _, target := range to {
+
 		err := s.client.Rcpt(target)
+
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			rejected = append(rejected, &RecipientError{Address: target, Err: err})
+			continue
+		}
 		if err != nil {
 			return err
 		}
 
+		accepted = append(accepted, target)
+	}
+
+	if len(accepted) == 0 {
+		return &PartialError{Rejected: rejected}
 	}
 
 	wc, err := s.client.Data()
 	if err != nil {
 		return err
 	}
-	defer wc.Close()
 
 	_, err = wc.Write(msg)
 	if err != nil {
+		wc.Close()
 		return err
 	}
 
+	if err := wc.Close(); err != nil {
+		return err
+	}
+
+	if len(rejected) > 0 {
+		return &PartialError{
+			Accepted: accepted,
+			Rejected: rejected,
+		}
+	}
+
 	return nil
 }
 
-func (s *SMTP) Close() {
-	s.conn.Close()
+// ReplyCode returns the SMTP reply code carried by err, or 0 when the error
+// did not come from a server reply.
+func ReplyCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
+
+func (s *SMTP) disconnect() {
 	s.client.Close()
+	s.conn.Close()
+	s.client = nil
+	s.conn = nil
+}
+
+func (s *SMTP) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		s.disconnect()
+	}
 }
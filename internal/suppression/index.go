@@ -0,0 +1,175 @@
+package suppression
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var entriesBucket = []byte("suppressions")
+
+var ErrNotFound = errors.New("suppression not found")
+
+// Sources of suppression entries.
+const (
+	SourceBounce      = "bounce"
+	SourceAdmin       = "admin"
+	SourceUnsubscribe = "unsubscribe"
+)
+
+// Entry stops the delivery to Address. An empty Scope suppresses the address
+// for every template group, otherwise only for the groups whose name or
+// category equals Scope.
+type Entry struct {
+	Address   string    `json:"address"`
+	Scope     string    `json:"scope"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// List is the persisted set of suppressed addresses.
+type List struct {
+	db *bbolt.DB
+}
+
+func New(path string) (*List, error) {
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(entriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &List{db: db}, nil
+}
+
+// Add stores entry, replacing an earlier entry for the same address and
+// scope. Addresses are compared case-insensitively.
+func (l *List) Add(entry *Entry) error {
+
+	entry.Address = strings.ToLower(strings.TrimSpace(entry.Address))
+	if entry.Address == "" {
+		return errors.New("address is required")
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(entriesBucket).Put(entryKey(entry.Scope, entry.Address), value)
+	})
+}
+
+// Remove deletes the entry of address in scope and returns it.
+func (l *List) Remove(address string, scope string) (*Entry, error) {
+
+	entry := &Entry{}
+	key := entryKey(scope, strings.ToLower(strings.TrimSpace(address)))
+
+	err := l.db.Update(func(tx *bbolt.Tx) error {
+
+		entries := tx.Bucket(entriesBucket)
+
+		value := entries.Get(key)
+		if value == nil {
+			return ErrNotFound
+		}
+
+		if err := json.Unmarshal(value, entry); err != nil {
+			return err
+		}
+
+		return entries.Delete(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Entries lists the suppressions, limited to address when it is not empty.
+func (l *List) Entries(address string) ([]*Entry, error) {
+
+	address = strings.ToLower(strings.TrimSpace(address))
+	entries := []*Entry{}
+
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(key, value []byte) error {
+
+			entry := &Entry{}
+			if err := json.Unmarshal(value, entry); err != nil {
+				return err
+			}
+
+			if address == "" || entry.Address == address {
+				entries = append(entries, entry)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Suppressed returns the entry that stops the delivery to address for any of
+// the given scopes, or nil when the address may be sent to.
+func (l *List) Suppressed(address string, scopes ...string) (*Entry, error) {
+
+	address = strings.ToLower(strings.TrimSpace(address))
+
+	var entry *Entry
+
+	err := l.db.View(func(tx *bbolt.Tx) error {
+
+		entries := tx.Bucket(entriesBucket)
+
+		for _, scope := range append([]string{""}, scopes...) {
+
+			value := entries.Get(entryKey(scope, address))
+			if value == nil {
+				continue
+			}
+
+			entry = &Entry{}
+			return json.Unmarshal(value, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (l *List) Close() error {
+	return l.db.Close()
+}
+
+func entryKey(scope string, address string) []byte {
+	return []byte(scope + "\x00" + address)
+}
@@ -2,25 +2,86 @@ package template
 
 import (
 	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"net/mail"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"golang.org/x/net/html"
 )
 
+const manifestFileName = "manifest.json"
+
+const defaultCategory = "transactional"
+
+// EncryptPGP requires the messages of a group to be OpenPGP encrypted.
+const EncryptPGP = "pgp"
+
+type Manifest struct {
+	Active string `json:"active"`
+	From   string `json:"from"`
+	// Category classifies the mail of the group, such as "transactional" or
+	// "marketing". Suppressions can be scoped to a category.
+	Category string `json:"category"`
+	// Bulk marks the group as bulk mail. Its messages carry one-click
+	// unsubscribe headers.
+	Bulk bool `json:"bulk"`
+	// Encrypt is the encryption the messages of the group require, "pgp"
+	// or empty for none.
+	Encrypt  string               `json:"encrypt"`
+	Variants map[string][]Variant `json:"variants"`
+}
+
+// Variant is a weighted alternative for a template name. Requests for the
+// name render one of its variants, chosen by the recipient addresses of the
+// message.
+type Variant struct {
+	Template string `json:"template"`
+	Weight   uint32 `json:"weight"`
+}
+
+type Group struct {
+	manifest Manifest
+	active   string
+	from     *mail.Address
+	versions map[string]*template.Template
+}
+
 type TemplateGroups struct {
-	dir       string
-	templates map[string]*template.Template
+	dir    string
+	groups map[string]*Group
+}
+
+type Mail struct {
+	Group   string
+	Version string
+	Names   []string
+	From    string
+	To      []string
+	Cc      []string
+	ReplyTo string
+	Headers map[string]string
+	Data    any
+}
+
+type Rendered struct {
+	Version string
+	Variant string
+	Text    []byte
 }
 
 func New(dirPath string) (*TemplateGroups, error) {
 
 	groups := &TemplateGroups{
-		dir:       dirPath,
-		templates: make(map[string]*template.Template),
+		dir:    dirPath,
+		groups: make(map[string]*Group),
 	}
 
 	dir, err := os.ReadDir(dirPath)
@@ -34,7 +95,7 @@ func New(dirPath string) (*TemplateGroups, error) {
 			continue
 		}
 
-		if err := groups.readTemplates(entry.Name()); err != nil {
+		if err := groups.readGroup(entry.Name()); err != nil {
 			return nil, err
 		}
 
@@ -43,20 +104,116 @@ func New(dirPath string) (*TemplateGroups, error) {
 	return groups, nil
 }
 
-func (groups *TemplateGroups) readTemplates(name string) error {
+// readGroup loads a template group. Template files placed directly in the
+// group directory form the unversioned ("") version, and every subdirectory
+// is loaded as a named version.
+func (groups *TemplateGroups) readGroup(name string) error {
 
 	dirPath := filepath.Join(groups.dir, name)
 
+	group := &Group{
+		versions: make(map[string]*template.Template),
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(dirPath, manifestFileName))
+	if err == nil {
+		if err := json.Unmarshal(manifest, &group.manifest); err != nil {
+			return fmt.Errorf("failed to parse manifest of group %s: %v", name, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
 	files, err := os.ReadDir(dirPath)
 	if err != nil {
 		return err
 	}
 
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		templates, err := readTemplates(filepath.Join(dirPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("group %s version %s: %v", name, file.Name(), err)
+		}
+
+		if templates != nil {
+			group.versions[file.Name()] = templates
+		}
+	}
+
+	templates, err := readTemplates(dirPath)
+	if err != nil {
+		return fmt.Errorf("group %s: %v", name, err)
+	}
+
+	if templates != nil {
+		group.versions[""] = templates
+	}
+
+	if len(group.versions) == 0 {
+		return fmt.Errorf("template group %s has no templates", name)
+	}
+
+	if group.manifest.From != "" {
+		from, err := mail.ParseAddress(group.manifest.From)
+		if err != nil {
+			return fmt.Errorf("invalid from address of group %s: %v", name, err)
+		}
+		group.from = from
+	}
+
+	switch group.manifest.Encrypt {
+	case "", EncryptPGP:
+	default:
+		return fmt.Errorf("unknown encryption %s of group %s", group.manifest.Encrypt, name)
+	}
+
+	for tmplName, variants := range group.manifest.Variants {
+		if len(variants) == 0 {
+			return fmt.Errorf("template %s in group %s declares no variants", tmplName, name)
+		}
+		for _, variant := range variants {
+			if variant.Template == "" || variant.Weight == 0 {
+				return fmt.Errorf("template %s in group %s has a variant without template or weight", tmplName, name)
+			}
+			for version, templates := range group.versions {
+				if templates.Lookup(variant.Template) == nil {
+					return fmt.Errorf("variant %s of template %s not found in group %s version %q", variant.Template, tmplName, name, version)
+				}
+			}
+		}
+	}
+
+	if group.manifest.Active != "" {
+		if _, exists := group.versions[group.manifest.Active]; !exists {
+			return fmt.Errorf("active version %s of group %s not found", group.manifest.Active, name)
+		}
+		group.active = group.manifest.Active
+	} else {
+		versions := group.Versions()
+		group.active = versions[len(versions)-1]
+	}
+
+	groups.groups[name] = group
+
+	return nil
+}
+
+func readTemplates(dirPath string) (*template.Template, error) {
+
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
 	filePaths := []string{}
 
 	for _, file := range files {
 
-		if file.IsDir() {
+		if file.IsDir() || file.Name() == manifestFileName {
 			continue
 		}
 
@@ -65,26 +222,155 @@ func (groups *TemplateGroups) readTemplates(name string) error {
 
 	}
 
+	if len(filePaths) == 0 {
+		return nil, nil
+	}
+
 	templates, err := template.ParseFiles(filePaths...)
 	if err != nil {
-		return fmt.Errorf("failed to parse templates: %v", err)
+		return nil, fmt.Errorf("failed to parse templates: %v", err)
+	}
+
+	return templates, nil
+}
+
+// Versions returns the names of the loaded versions in natural order, so
+// that "v10" follows "v9". The unversioned templates, if any, are reported
+// as "".
+func (group *Group) Versions() []string {
+	versions := make([]string, 0, len(group.versions))
+	for version := range group.versions {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions
+}
+
+// compareVersions compares version names by their runs of digits and
+// non-digits, comparing the runs of digits as numbers.
+func compareVersions(a string, b string) int {
+
+	for a != "" && b != "" {
+
+		runA, restA := versionRun(a)
+		runB, restB := versionRun(b)
+
+		if isDigit(runA[0]) && isDigit(runB[0]) {
+			numA := strings.TrimLeft(runA, "0")
+			numB := strings.TrimLeft(runB, "0")
+			if len(numA) != len(numB) {
+				return cmp.Compare(len(numA), len(numB))
+			}
+			if c := strings.Compare(numA, numB); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(runA, runB); c != 0 {
+			return c
+		}
+
+		a, b = restA, restB
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+// versionRun splits the leading run of digits or non-digits off s.
+func versionRun(s string) (string, string) {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func (group *Group) Active() string {
+	return group.active
+}
+
+// From returns the sender configured for the group, or nil when the group
+// uses the default sender.
+func (group *Group) From() *mail.Address {
+	return group.from
+}
+
+// Category returns the category of the group, "transactional" unless the
+// manifest sets one.
+func (group *Group) Category() string {
+	if group.manifest.Category == "" {
+		return defaultCategory
 	}
+	return group.manifest.Category
+}
 
-	groups.templates[name] = templates
+func (group *Group) Bulk() bool {
+	return group.manifest.Bulk
+}
+
+// Encrypt returns the encryption the messages of the group require, or an
+// empty string.
+func (group *Group) Encrypt() string {
+	return group.manifest.Encrypt
+}
+
+func (groups *TemplateGroups) Group(name string) (*Group, bool) {
+	group, exists := groups.groups[name]
+	return group, exists
+}
+
+// SetActive changes the version rendered for requests of the group that do
+// not pin a version.
+func (groups *TemplateGroups) SetActive(name string, version string) error {
+
+	group, exists := groups.groups[name]
+	if !exists {
+		return fmt.Errorf("template group not found: %s", name)
+	}
+
+	if _, exists := group.versions[version]; !exists {
+		return fmt.Errorf("version %s not found in group %s", version, name)
+	}
+
+	group.active = version
 
 	return nil
 }
 
-func (groups *TemplateGroups) ToText(group string, names []string, from string, to []string, data any) ([]byte, error) {
+func (groups *TemplateGroups) ToText(mail *Mail) (*Rendered, error) {
+
+	group, exists := groups.groups[mail.Group]
+	if !exists {
+		return nil, fmt.Errorf("template group not found: %s", mail.Group)
+	}
+
+	version := mail.Version
+	if version == "" {
+		version = group.active
+	}
 
-	templates, exists := groups.templates[group]
+	templates, exists := group.versions[version]
 	if !exists {
-		return nil, fmt.Errorf("template group not found: %s", group)
+		return nil, fmt.Errorf("version %s not found in group %s", version, mail.Group)
 	}
 
 	var tmpl *template.Template
+	var variant string
+
+	for _, name := range append(mail.Names, "default") {
+		if variants, exists := group.manifest.Variants[name]; exists {
+			variant = selectVariant(variants, mail.Group, name, mail.To)
+			tmpl = templates.Lookup(variant)
+			if tmpl == nil {
+				return nil, fmt.Errorf("variant %s of template %s not found in group %s", variant, name, mail.Group)
+			}
+			break
+		}
 
-	for _, name := range append(names, "default") {
 		tmpl = templates.Lookup(name)
 		if tmpl != nil {
 			break
@@ -92,34 +378,113 @@ func (groups *TemplateGroups) ToText(group string, names []string, from string,
 	}
 
 	if tmpl == nil {
-		return nil, fmt.Errorf("template not found: %s in group %s", names, group)
+		return nil, fmt.Errorf("template not found: %s in group %s", mail.Names, mail.Group)
 	}
 
 	var body bytes.Buffer
 
-	err := tmpl.Execute(&body, data)
+	err := tmpl.Execute(&body, mail.Data)
 	if err != nil {
-		return nil, fmt.Errorf("template execution error for %s in group %s: %v", tmpl.Name(), group, err)
+		return nil, fmt.Errorf("template execution error for %s in group %s: %v", tmpl.Name(), mail.Group, err)
 	}
 
 	content := bytes.TrimSpace(body.Bytes())
 
 	title, err := extractTitle(&body)
 	if err != nil {
-		return nil, fmt.Errorf("template %s in group %s title not found: %v", tmpl.Name(), group, err)
+		return nil, fmt.Errorf("template %s in group %s title not found: %v", tmpl.Name(), mail.Group, err)
+	}
+
+	head, err := writeHead(mail, title)
+	if err != nil {
+		return nil, fmt.Errorf("template %s in group %s: %v", tmpl.Name(), mail.Group, err)
+	}
+
+	rendered := &Rendered{
+		Version: version,
+		Variant: variant,
+		Text:    append(head, content...),
+	}
+
+	return rendered, nil
+
+}
+
+func writeHead(mail *Mail, title string) ([]byte, error) {
+
+	var head bytes.Buffer
+
+	headers := [][2]string{
+		{"From", mail.From},
+		{"To", strings.Join(mail.To, ", ")},
 	}
 
-	head := fmt.Sprintf(`From: %s
-To: %s
-Subject: %s
-MIME-version: 1.0;
+	if len(mail.Cc) > 0 {
+		headers = append(headers, [2]string{"Cc", strings.Join(mail.Cc, ", ")})
+	}
+
+	if mail.ReplyTo != "" {
+		headers = append(headers, [2]string{"Reply-To", mail.ReplyTo})
+	}
+
+	headers = append(headers, [2]string{"Subject", strings.Join(strings.Fields(title), " ")})
+
+	keys := make([]string, 0, len(mail.Headers))
+	for key := range mail.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		headers = append(headers, [2]string{key, mail.Headers[key]})
+	}
+
+	for _, header := range headers {
+		if strings.ContainsAny(header[0], "\r\n: ") || strings.ContainsAny(header[1], "\r\n") {
+			return nil, fmt.Errorf("invalid %s header", header[0])
+		}
+		fmt.Fprintf(&head, "%s: %s\n", header[0], header[1])
+	}
+
+	head.WriteString(`MIME-version: 1.0;
 Content-Type: text/html; charset="UTF-8";
 
 
-`, from, strings.Join(to, ", "), title)
+`)
+
+	return head.Bytes(), nil
+}
 
-	return append([]byte(head), content...), nil
+// selectVariant picks a variant by hashing the recipients of the message,
+// so the same recipients always receive the same variant of a template. A
+// message is rendered once, so all recipients of a message share its
+// variant; requests that need one variant per address use mail merge
+// recipients.
+func selectVariant(variants []Variant, group string, name string, to []string) string {
+
+	var total uint64
+	for _, variant := range variants {
+		total += uint64(variant.Weight)
+	}
+
+	recipients := make([]string, 0, len(to))
+	for _, address := range to {
+		recipients = append(recipients, strings.ToLower(strings.TrimSpace(address)))
+	}
+	sort.Strings(recipients)
+
+	hash := fnv.New64a()
+	hash.Write([]byte(group + "/" + name + "/" + strings.Join(recipients, ",")))
+	point := hash.Sum64() % total
+
+	for _, variant := range variants {
+		if point < uint64(variant.Weight) {
+			return variant.Template
+		}
+		point -= uint64(variant.Weight)
+	}
 
+	return variants[len(variants)-1].Template
 }
 
 func extractTitle(body *bytes.Buffer) (string, error) {
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/mimepart"
+)
+
+// File drops every message into a directory as an .eml file, with CRLF
+// line endings and the envelope recorded in X-Envelope-* header fields.
+// Files are written under a temporary name and renamed, so that a process
+// picking them up never sees a partial message.
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) (*File, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &File{dir: dir}, nil
+}
+
+func (f *File) Send(from string, to []string, msg []byte) error {
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + hex.EncodeToString(suffix)
+
+	data := mimepart.CRLF(append([]byte(envelope(from, to)), msg...))
+
+	tmp := filepath.Join(f.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("file transport: %v", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(f.dir, name+".eml")); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("file transport: %v", err)
+	}
+
+	return nil
+}
+
+func (f *File) Close() {}
@@ -0,0 +1,20 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transport hands composed messages over for delivery. Messages use "\n"
+// line endings. Errors that carry a server reply should wrap a
+// *textproto.Error, so that the reply code decides about retries.
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+	Close()
+}
+
+// envelope returns header fields that record the envelope of a message
+// written to a sink, as the envelope is otherwise lost.
+func envelope(from string, to []string) string {
+	return fmt.Sprintf("X-Envelope-From: <%s>\nX-Envelope-To: %s\n", from, strings.Join(to, ", "))
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Message is a message captured by a Memory transport.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	At   time.Time
+}
+
+// Memory keeps the sent messages in memory, for tests and dry runs. Only
+// the last limit messages are kept when limit is positive.
+type Memory struct {
+	mu       sync.Mutex
+	limit    int
+	messages []*Message
+}
+
+func NewMemory(limit int) *Memory {
+	return &Memory{limit: limit}
+}
+
+func (m *Memory) Send(from string, to []string, msg []byte) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, &Message{
+		From: from,
+		To:   slices.Clone(to),
+		Data: bytes.Clone(msg),
+		At:   time.Now(),
+	})
+
+	if m.limit > 0 && len(m.messages) > m.limit {
+		m.messages = slices.Delete(m.messages, 0, len(m.messages)-m.limit)
+	}
+
+	return nil
+}
+
+// Messages returns the captured messages, oldest first.
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.messages)
+}
+
+// Reset drops the captured messages.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
+
+func (m *Memory) Close() {}
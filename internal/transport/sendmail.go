@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExitError is returned when the sendmail command fails. It wraps a
+// *textproto.Error whose code tells whether the failure is permanent, as
+// derived from the sysexits.h status of the command.
+type ExitError struct {
+	Status int
+	Stderr string
+	reply  *textproto.Error
+}
+
+func (err *ExitError) Error() string {
+	if err.Stderr == "" {
+		return fmt.Sprintf("sendmail exited with status %d", err.Status)
+	}
+	return fmt.Sprintf("sendmail exited with status %d: %s", err.Status, err.Stderr)
+}
+
+func (err *ExitError) Unwrap() error {
+	return err.reply
+}
+
+// Permanent sendmail exit statuses of sysexits.h. Every other status is
+// treated as a temporary failure.
+var permanentStatuses = map[int]struct{}{
+	65: {}, // EX_DATAERR
+	67: {}, // EX_NOUSER
+	68: {}, // EX_NOHOST
+	77: {}, // EX_NOPERM
+}
+
+// Sendmail pipes messages to a local MTA through a sendmail compatible
+// command, such as "/usr/sbin/sendmail -i". The envelope is passed as
+// "-f from -- to...". Reading the recipients from the header with -t is not
+// supported, as it would skip the Bcc recipients and deliver to recipients
+// that were already completed or suppressed.
+type Sendmail struct {
+	command []string
+	timeout time.Duration
+}
+
+func NewSendmail(command string, timeout time.Duration) (*Sendmail, error) {
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("sendmail transport: no command configured")
+	}
+
+	if slices.Contains(fields[1:], "-t") {
+		return nil, errors.New("sendmail transport: -t is not supported, the recipients are passed as arguments")
+	}
+
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return nil, fmt.Errorf("sendmail transport: %v", err)
+	}
+
+	return &Sendmail{
+		command: fields,
+		timeout: timeout,
+	}, nil
+}
+
+func (s *Sendmail) Send(from string, to []string, msg []byte) error {
+
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	args := slices.Clone(s.command[1:])
+
+	if from != "" {
+		args = append(args, "-f", from)
+	}
+
+	args = append(args, "--")
+	args = append(args, to...)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+		// The command could not be started or was killed, for example by
+		// the timeout.
+		return fmt.Errorf("sendmail transport: %v", err)
+	}
+
+	status := exitErr.ExitCode()
+	message := strings.TrimSpace(stderr.String())
+
+	code := 451
+	if _, permanent := permanentStatuses[status]; permanent {
+		code = 550
+	}
+
+	return &ExitError{
+		Status: status,
+		Stderr: message,
+		reply:  &textproto.Error{Code: code, Msg: message},
+	}
+}
+
+func (s *Sendmail) Close() {}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stdout prints every message with its envelope, for local development.
+type Stdout struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdout() *Stdout {
+	return &Stdout{w: os.Stdout}
+}
+
+func (s *Stdout) Send(from string, to []string, msg []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "----- message -----\n%s%s\n----- end of message -----\n", envelope(from, to), msg)
+
+	return err
+}
+
+func (s *Stdout) Close() {}
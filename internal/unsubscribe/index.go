@@ -0,0 +1,94 @@
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// Signer creates and verifies the unsubscribe links of bulk mail. A token
+// carries the recipient address and the suppression scope, signed with
+// HMAC-SHA256 so that it cannot be forged for other addresses.
+type Signer struct {
+	url    *url.URL
+	secret []byte
+}
+
+// New returns a signer for links to endpoint, the public URL of the
+// unsubscribe endpoint of the HTTP listener.
+func New(endpoint string, secret string) (*Signer, error) {
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("unsubscribe url must be absolute")
+	}
+
+	return &Signer{
+		url:    u,
+		secret: []byte(secret),
+	}, nil
+}
+
+// URL returns the unsubscribe link of address for scope. The token is added
+// to the query the endpoint may already have.
+func (s *Signer) URL(address string, scope string) string {
+
+	u := *s.url
+
+	query := u.Query()
+	query.Set("token", s.Token(address, scope))
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// Token returns the signed token of address and scope.
+func (s *Signer) Token(address string, scope string) string {
+	payload := []byte(strings.ToLower(address) + "\n" + scope)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Verify checks token and returns the address and scope it carries.
+func (s *Signer) Verify(token string) (string, string, error) {
+
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, s.sign(payload)) {
+		return "", "", ErrInvalidToken
+	}
+
+	address, scope, found := strings.Cut(string(payload), "\n")
+	if !found || address == "" {
+		return "", "", ErrInvalidToken
+	}
+
+	return address, scope, nil
+}
+
+func (s *Signer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,414 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucap9056/mail-template-sender/internal/queue"
+	"go.etcd.io/bbolt"
+)
+
+var deliveriesBucket = []byte("deliveries")
+
+const idleInterval = 5 * time.Second
+
+// eventBuffer is the number of published events that may wait to be
+// stored. Events published while it is full are dropped.
+const eventBuffer = 1024
+
+type Config struct {
+	URLs []string
+	// Secret is the HMAC-SHA256 key used to sign the events.
+	Secret string
+	// Statuses limits the events to the given statuses. All status changes
+	// are sent when it is empty.
+	Statuses []string
+	Timeout  time.Duration
+	Retry    *queue.RetryPolicy
+	// Workers is the number of deliveries posted at the same time. Each
+	// endpoint receives one delivery at a time, so that a slow endpoint
+	// holds up a single worker.
+	Workers int
+}
+
+// Event is the JSON body posted to the webhook endpoints.
+type Event struct {
+	ID   string       `json:"id"`
+	Type string       `json:"type"`
+	Data *queue.Event `json:"data"`
+}
+
+// delivery is a pending POST of an event to one endpoint.
+type delivery struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Body     []byte    `json:"body"`
+	Attempts int       `json:"attempts"`
+	DueAt    time.Time `json:"due_at"`
+}
+
+// Webhooks posts message events to the configured endpoints. Deliveries are
+// persisted in their own bbolt database and retried until they succeed or
+// run out of attempts.
+type Webhooks struct {
+	cfg      *Config
+	statuses map[queue.Status]struct{}
+	db       *bbolt.DB
+	client   *http.Client
+	wake     chan struct{}
+	events   chan *queue.Event
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	// posting holds the endpoints with a delivery in progress.
+	posting map[string]struct{}
+}
+
+func New(path string, cfg *Config) (*Webhooks, error) {
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deliveriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	statuses := make(map[queue.Status]struct{})
+	for _, status := range cfg.Statuses {
+		statuses[queue.Status(status)] = struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	webhooks := &Webhooks{
+		cfg:      cfg,
+		statuses: statuses,
+		db:       db,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		wake:     make(chan struct{}, 1),
+		events:   make(chan *queue.Event, eventBuffer),
+		ctx:      ctx,
+		cancel:   cancel,
+		posting:  make(map[string]struct{}),
+	}
+
+	return webhooks, nil
+}
+
+// Publish hands event over to be stored as a delivery for every endpoint.
+// It is meant to be subscribed to the message queue and does not block:
+// the deliveries are stored by a goroutine of their own, and events are
+// dropped when too many wait for it.
+func (w *Webhooks) Publish(event *queue.Event) {
+
+	if len(w.statuses) > 0 {
+		if _, ok := w.statuses[event.Status]; !ok {
+			return
+		}
+	}
+
+	select {
+	case w.events <- event:
+	default:
+		log.Printf("webhook event of message %s dropped, too many events are waiting\n", event.MessageID)
+	}
+}
+
+// store stores the published events until Close, together with the ones
+// that are waiting, so that a burst of events takes a single write.
+func (w *Webhooks) store() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			w.storeEvents(w.waiting(nil))
+			return
+		case event := <-w.events:
+			w.storeEvents(w.waiting([]*queue.Event{event}))
+		}
+	}
+}
+
+// waiting appends the buffered events to events without blocking.
+func (w *Webhooks) waiting(events []*queue.Event) []*queue.Event {
+	for {
+		select {
+		case event := <-w.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// storeEvents stores a delivery of each event for every endpoint.
+func (w *Webhooks) storeEvents(events []*queue.Event) {
+
+	if len(events) == 0 {
+		return
+	}
+
+	deliveries := []*delivery{}
+
+	for _, event := range events {
+
+		id, err := newID()
+		if err != nil {
+			log.Println("webhook event error: ", err.Error())
+			continue
+		}
+
+		body, err := json.Marshal(&Event{
+			ID:   id,
+			Type: "message." + string(event.Status),
+			Data: event,
+		})
+		if err != nil {
+			log.Println("webhook event error: ", err.Error())
+			continue
+		}
+
+		for i, url := range w.cfg.URLs {
+			deliveries = append(deliveries, &delivery{
+				ID:    fmt.Sprintf("%s-%d", id, i),
+				URL:   url,
+				Body:  body,
+				DueAt: time.Now(),
+			})
+		}
+	}
+
+	err := w.db.Update(func(tx *bbolt.Tx) error {
+		for _, d := range deliveries {
+			if err := putDelivery(tx, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("webhook event error: ", err.Error())
+		return
+	}
+
+	w.notify()
+}
+
+// Start launches the delivery workers and the goroutine storing the
+// published events.
+func (w *Webhooks) Start() {
+
+	w.wg.Add(1)
+	go w.store()
+
+	for i := 0; i < max(1, w.cfg.Workers); i++ {
+		w.wg.Add(1)
+		go w.work()
+	}
+}
+
+func (w *Webhooks) work() {
+	defer w.wg.Done()
+
+	for {
+		d, wait, err := w.next(time.Now())
+		if err != nil {
+			log.Println("webhook queue error: ", err.Error())
+			wait = idleInterval
+		}
+
+		if d == nil {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-w.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		err = w.post(d)
+
+		if err := w.complete(d, err); err != nil {
+			log.Println("webhook queue error: ", err.Error())
+		}
+
+		w.mu.Lock()
+		delete(w.posting, d.URL)
+		w.mu.Unlock()
+
+		// Deliveries to the endpoint may have waited for this one.
+		w.notify()
+	}
+}
+
+// next returns the earliest due delivery to an endpoint that is not being
+// posted to, or how long to wait for one. The endpoint of the returned
+// delivery is marked as being posted to.
+func (w *Webhooks) next(now time.Time) (*delivery, time.Duration, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var d *delivery
+	wait := idleInterval
+
+	err := w.db.View(func(tx *bbolt.Tx) error {
+
+		cursor := tx.Bucket(deliveriesBucket).Cursor()
+
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+
+			due := time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+			if due.After(now) {
+				wait = min(due.Sub(now), idleInterval)
+				return nil
+			}
+
+			candidate := &delivery{}
+			if err := json.Unmarshal(value, candidate); err != nil {
+				return err
+			}
+
+			if _, busy := w.posting[candidate.URL]; !busy {
+				d = candidate
+				return nil
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if d != nil {
+		w.posting[d.URL] = struct{}{}
+	}
+
+	return d, wait, nil
+}
+
+func (w *Webhooks) post(d *delivery) error {
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(w.cfg.Secret, timestamp, d.Body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// complete removes a finished delivery, or reschedules it when the attempt
+// failed and retries are left.
+func (w *Webhooks) complete(d *delivery, err error) error {
+
+	return w.db.Update(func(tx *bbolt.Tx) error {
+
+		deliveries := tx.Bucket(deliveriesBucket)
+		if err := deliveries.Delete(deliveryKey(d)); err != nil {
+			return err
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		d.Attempts++
+
+		if w.cfg.Retry.MaxAttempts > 0 && d.Attempts >= w.cfg.Retry.MaxAttempts {
+			log.Printf("webhook %s to %s dropped: %s\n", d.ID, d.URL, err.Error())
+			return nil
+		}
+
+		d.DueAt = time.Now().Add(w.cfg.Retry.Backoff(d.Attempts))
+		log.Printf("webhook %s to %s deferred until %s: %s\n", d.ID, d.URL, d.DueAt.Format(time.RFC3339), err.Error())
+
+		return putDelivery(tx, d)
+	})
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of timestamp and body, joined by
+// a dot. Receivers compute the same value to verify an event.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhooks) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the workers and closes the database.
+func (w *Webhooks) Close() error {
+	w.cancel()
+	w.wg.Wait()
+	return w.db.Close()
+}
+
+func putDelivery(tx *bbolt.Tx, d *delivery) error {
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(deliveriesBucket).Put(deliveryKey(d), value)
+}
+
+// deliveryKey orders the deliveries by due time.
+func deliveryKey(d *delivery) []byte {
+	var key bytes.Buffer
+	binary.Write(&key, binary.BigEndian, uint64(d.DueAt.UnixNano()))
+	key.WriteString(d.ID)
+	return key.Bytes()
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}